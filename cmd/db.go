@@ -0,0 +1,286 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/godovasik/dawgobot/internal/config"
+	database "github.com/godovasik/dawgobot/internal/database"
+	"github.com/godovasik/dawgobot/internal/twitch"
+)
+
+// dbConfig - настройки базы из конфига, флаг -db главнее всего
+func dbConfig(cfg *config.Config) database.Config {
+	dbCfg := cfg.Database
+	if *dbPath != "" {
+		dbCfg.Path = *dbPath
+	}
+	return dbCfg
+}
+
+// openDB открывает базу по конфигу и флагу -db, с миграциями
+func openDB() (*database.DB, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	return database.New(dbConfig(cfg))
+}
+
+// runMigrate - "migrate" показывает версию схемы и что еще не применено, "migrate up" применяет
+func runMigrate(args []string) {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// migrate - способ завести новую базу, поэтому создаем файл если его нет
+	dbCfg := dbConfig(cfg)
+	dbCfg.Create = true
+	db, err := database.Open(dbCfg)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer db.Close()
+
+	if len(args) > 0 && args[0] == "up" {
+		applied, err := db.Migrate()
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if len(applied) == 0 {
+			fmt.Println("nothing to migrate")
+		}
+	} else if len(args) > 0 {
+		fmt.Println("usage: migrate [up]")
+		return
+	}
+
+	current, pending, err := db.SchemaVersion()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("current version:", current)
+	if len(pending) == 0 {
+		fmt.Println("schema is up to date")
+		return
+	}
+	fmt.Println("pending:")
+	for _, m := range pending {
+		fmt.Printf("  %04d_%s\n", m.Version, m.Name)
+	}
+}
+
+// runSearch - "search [-streamer ник] [-from 2025-06-01] [-to 2025-06-30] [-limit 20] [-fts] текст"
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	streamer := fs.String("streamer", "", "искать только в этом канале")
+	from := fs.String("from", "", "с какой даты, 2006-01-02")
+	to := fs.String("to", "", "по какую дату включительно, 2006-01-02")
+	limit := fs.Int("limit", 20, "сколько результатов показать")
+	raw := fs.Bool("fts", false, "запрос в синтаксисе FTS5 (OR, NOT, префикс*), иначе ищется фраза целиком")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Println("usage: search [-streamer ник] [-from дата] [-to дата] [-limit n] [-fts] текст")
+		return
+	}
+	query := strings.Join(fs.Args(), " ")
+	if !*raw {
+		query = database.PhraseQuery(query)
+	}
+
+	fromTime, toTime, err := parseDateRange(*from, *to)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	db, err := openDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer db.Close()
+
+	results, err := db.SearchEvents(query, strings.ToLower(*streamer), fromTime, toTime, *limit)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(results) == 0 {
+		fmt.Println("nothing found")
+		return
+	}
+	for _, r := range results {
+		e := r.Event
+		mark := ""
+		if e.Moderation != "" {
+			mark = " (" + e.Moderation + ")"
+		}
+		fmt.Printf("%s [%s] %s: %s%s\n", e.Timestamp.Local().Format(time.DateTime), e.Streamer, e.Author, r.Snippet, mark)
+	}
+}
+
+// runExport - датасет для лоры: "export [-streamer a,b] [-from дата] [-to дата] [-types chat,image,speech] [-out datasets]"
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	streamers := fs.String("streamer", "", "каналы через запятую, пусто - все")
+	from := fs.String("from", "", "с какой даты, 2006-01-02")
+	to := fs.String("to", "", "по какую дату включительно, 2006-01-02")
+	types := fs.String("types", "chat,image,speech", "какие события идут в контекст")
+	window := fs.Int("window", 20, "сколько событий в контексте")
+	stride := fs.Int("stride", 1, "брать каждое n-е сообщение как ответ")
+	valFraction := fs.Float64("val", 0.1, "доля дней в validation")
+	out := fs.String("out", "datasets", "куда положить train.jsonl и val.jsonl")
+	raw := fs.Bool("raw", false, "не анонимизировать ники и не вырезать ссылки (opt_out все равно работает)")
+	fs.Parse(args)
+
+	fromTime, toTime, err := parseDateRange(*from, *to)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	db, err := database.New(dbConfig(cfg))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer db.Close()
+
+	privacy := cfg.Privacy
+	privacy.Keep = append(privacy.Keep, twitch.BotName)
+	if *raw {
+		privacy.Pseudonymize = false
+		privacy.Redact = false
+	}
+
+	opts := database.DatasetOptions{
+		From:           fromTime,
+		To:             toTime,
+		Window:         *window,
+		Stride:         *stride,
+		ValFraction:    *valFraction,
+		ExcludeAuthors: []string{twitch.BotName}, // свои ответы не учим
+		Anonymizer:     database.NewAnonymizer(privacy),
+	}
+	if *streamers != "" {
+		opts.Streamers = strings.Split(*streamers, ",")
+	}
+	for _, name := range strings.Split(*types, ",") {
+		t, err := db.ParseEventType(strings.TrimSpace(name))
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		opts.Types = append(opts.Types, t)
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		fmt.Println(err)
+		return
+	}
+	train, err := os.Create(filepath.Join(*out, "train.jsonl"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer train.Close()
+	val, err := os.Create(filepath.Join(*out, "val.jsonl"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer val.Close()
+
+	stats, err := db.ExportDataset(opts, train, val)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("read %d events, wrote %d train and %d val samples to %s\n", stats.Events, stats.Train, stats.Val, *out)
+}
+
+// parseDateRange - даты из флагов, to включает весь день. пустые - нулевое время
+func parseDateRange(from, to string) (time.Time, time.Time, error) {
+	var fromTime, toTime time.Time
+	var err error
+	if from != "" {
+		if fromTime, err = time.ParseInLocation(time.DateOnly, from, time.Local); err != nil {
+			return fromTime, toTime, fmt.Errorf("bad -from: %w", err)
+		}
+	}
+	if to != "" {
+		if toTime, err = time.ParseInLocation(time.DateOnly, to, time.Local); err != nil {
+			return fromTime, toTime, fmt.Errorf("bad -to: %w", err)
+		}
+		toTime = toTime.Add(24*time.Hour - time.Nanosecond)
+	}
+	return fromTime, toTime, nil
+}
+
+// runPrune - "prune [--dry-run]" удаляет события старше сроков из retention в конфиге
+func runPrune(args []string) {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "только показать, что будет удалено")
+	fs.Parse(args)
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(cfg.Retention.Rules) == 0 {
+		fmt.Println("no retention rules in config, everything is kept forever")
+		return
+	}
+
+	db, err := database.New(dbConfig(cfg))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer db.Close()
+
+	stats, err := db.Prune(cfg.Retention, *dryRun)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(stats.Groups) == 0 {
+		fmt.Println("nothing to prune")
+		return
+	}
+
+	total := 0
+	for _, g := range stats.Groups {
+		fmt.Printf("%-20s %-12s older than %s: %d\n", g.Streamer, db.EventTypeName(g.Type), g.Cutoff.Format(time.DateTime), g.Count)
+		total += g.Count
+	}
+	if *dryRun {
+		fmt.Printf("would delete %d events\n", total)
+		return
+	}
+	fmt.Printf("deleted %d events\n", stats.Deleted)
+	if stats.Archive != "" {
+		fmt.Println("archived to", stats.Archive)
+	}
+}
@@ -2,25 +2,36 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/godovasik/dawgobot/internal/ai"
 	"github.com/godovasik/dawgobot/internal/ai/deepseek"
+	"github.com/godovasik/dawgobot/internal/ai/fallback"
 	"github.com/godovasik/dawgobot/internal/ai/ollama"
 	"github.com/godovasik/dawgobot/internal/ai/openrouter"
+	"github.com/godovasik/dawgobot/internal/ai/prompts"
 	"github.com/godovasik/dawgobot/internal/client"
-	database "github.com/godovasik/dawgobot/internal/database"
+	"github.com/godovasik/dawgobot/internal/commands"
+	"github.com/godovasik/dawgobot/internal/config"
+	"github.com/godovasik/dawgobot/internal/fetch"
 	"github.com/godovasik/dawgobot/internal/timeline"
 	"github.com/godovasik/dawgobot/internal/twitch"
 	"github.com/godovasik/dawgobot/logger"
 )
 
-// можно переписать с "flags", но мне лень.
+var dbPath = flag.String("db", "", "путь к базе, главнее database.path из конфига и DAWGOBOT_DB")
+
 func main() {
-	if len(os.Args) < 2 {
+	flag.Parse()
+	args := flag.Args()
+
+	if len(args) < 1 {
 		fmt.Println("no argument here...")
 		// testLlava()
 		// testMonitorChat()
@@ -43,6 +54,10 @@ func main() {
 		// testSqlite()
 		// testMonitorChatEvents()
 
+		// testFallback()
+		// testPromptsReload()
+		// testCommands()
+
 		testGemini()
 		// testRouterAgain()
 
@@ -50,15 +65,23 @@ func main() {
 
 		return
 	}
-	switch os.Args[1] {
+	switch args[0] {
 	case "log":
 		fmt.Println("kek")
+	case "migrate":
+		runMigrate(args[1:])
+	case "search":
+		runSearch(args[1:])
+	case "export":
+		runExport(args[1:])
+	case "prune":
+		runPrune(args[1:])
 	case "img":
 		// ReactToImages()
 	case "last":
 		streamer := ""
-		if len(os.Args) >= 3 {
-			streamer = os.Args[2]
+		if len(args) >= 2 {
+			streamer = args[1]
 			fmt.Printf("last events for %s:\n", streamer)
 			testGetEvents(streamer)
 		} else {
@@ -67,15 +90,15 @@ func main() {
 		}
 	case "count":
 		streamer := ""
-		if len(os.Args) < 3 {
+		if len(args) < 2 {
 			streamer = "dawgonosik"
 		} else {
-			streamer = os.Args[2]
+			streamer = args[1]
 		}
 		testEventsCount(streamer)
 	case "monitor":
 		boys := []string{}
-		if len(os.Args) < 3 {
+		if len(args) < 2 {
 			boys = []string{
 				"dawgonosik",
 				"hak3li",
@@ -86,13 +109,13 @@ func main() {
 				"lesnoybol1",
 			}
 		} else {
-			boys = append(boys, os.Args[2])
+			boys = append(boys, args[1])
 		}
 		fmt.Println("monitoring chat for", boys)
 		testMonitorChatEventsWithImages(false, boys...)
 	case "images":
 		boys := []string{}
-		if len(os.Args) < 3 {
+		if len(args) < 2 {
 			boys = []string{
 				"dawgonosik",
 				"hak3li",
@@ -103,14 +126,14 @@ func main() {
 				"lesnoybol1",
 			}
 		} else {
-			boys = append(boys, os.Args[2])
+			boys = append(boys, args[1])
 		}
 		fmt.Println("monitoring chat with images for", boys)
 		testMonitorChatEventsWithImages(true, boys...)
 
 	case "replyimg":
 		boys := []string{}
-		if len(os.Args) < 3 {
+		if len(args) < 2 {
 			boys = []string{
 				"dawgonosik",
 				"hak3li",
@@ -121,7 +144,7 @@ func main() {
 				"lesnoybol1",
 			}
 		} else {
-			boys = append(boys, os.Args[2])
+			boys = append(boys, args[1])
 		}
 		testReplyToImages(boys...)
 		fmt.Println("mok")
@@ -146,29 +169,36 @@ func testTwitchApi() {
 }
 
 func testGemini() {
-	err := openrouter.LoadCharacters()
+	cfg, err := config.Load()
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	client, err := openrouter.GetNewClient(true)
+	reg, err := prompts.Load(cfg.Prompts.Path)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	client, err := openrouter.GetNewClient(cfg.OpenRouter, reg, true)
 	if err != nil {
 		fmt.Println("getnewclient err,", err)
 		return
 	}
 	url := "https://cdn.discordapp.com/attachments/1222897393666883624/1387746763577757787/500.jpg?ex=685e7763&is=685d25e3&hm=fe7ce51bea4058d8b80be713ac91e3c0104b5ec92411563edde5c987f8566e6a&"
 	ctx := context.Background()
-	resp, err := client.DescribeImageGemeni(ctx, url)
+	resp, err := client.DescribeImage(ctx, ai.ImageRequest{URL: url})
 	if err != nil {
 		fmt.Println("describeImage error:", err)
 		return
 	}
-	fmt.Println(resp)
+	fmt.Println(resp.Text)
+	fmt.Println("tokens:", resp.Usage.TotalTokens)
 }
 
 func testEventsCount(streamer string) {
-	db, err := database.New()
+	db, err := openDB()
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -182,7 +212,7 @@ func testEventsCount(streamer string) {
 }
 
 func testGetAllEvents() {
-	db, err := database.New()
+	db, err := openDB()
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -203,36 +233,51 @@ func testReplyToImages(channels ...string) {
 		return
 	}
 
-	err = openrouter.LoadCharacters()
+	cfg, err := config.Load()
 	if err != nil {
 		logger.Error(err.Error())
 		return
 	}
 
-	err = deepseek.LoadCharacters() // this is ugly
+	reg, err := prompts.Load(cfg.Prompts.Path)
 	if err != nil {
 		logger.Error(err.Error())
 		return
 	}
 
-	gmn, err := openrouter.GetNewClient(false)
+	gmn, err := openrouter.GetNewClient(cfg.OpenRouter, reg, false)
 	if err != nil {
 		logger.Error(err.Error())
 		return
 	}
 
-	ds, err := deepseek.NewClient()
+	chat, err := newChatChain(cfg, reg, gmn)
 	if err != nil {
 		logger.Error(err.Error())
 		return
 	}
 
+	// база нужна только как запасной источник контекста для тегов
+	db, err := openDB()
+	if err != nil {
+		logger.Warnf("running without db: %v", err)
+	}
+
+	fetch.SetDefault(cfg.Fetch)
+	outbox := twitch.NewOutbox(tw.TWClient, cfg.Outbox)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	client := client.NewClientBuilder().
 		WithTwitch(tw).
 		WithContext(ctx, cancel).
-		WithGemeni(gmn).
-		WithDeepseek(ds).
+		WithVision(gmn).
+		WithChat(chat).
+		WithPrompts(reg, cfg.Prompts.ReloadInterval).
+		WithTimeline(timeline.NewTimeline(500)).
+		WithDB(db).
+		WithChannels(cfg).
+		WithOutbox(outbox).
+		WithCommands(newCommands(outbox, reg)).
 		Build()
 
 	err = client.ReactToImages(channels...)
@@ -248,8 +293,64 @@ func testReplyToImages(channels ...string) {
 	}
 }
 
+// newChatChain собирает текстовые модели в fallback.Chain в порядке из конфига.
+// бэкенды, которые не получилось создать (нет токена), пропускаются.
+func newChatChain(cfg *config.Config, reg *prompts.Registry, or *openrouter.Client) (ai.ChatModel, error) {
+	var backends []fallback.Backend
+	for _, name := range cfg.Fallback.Order {
+		switch name {
+		case "deepseek":
+			ds, err := deepseek.NewClient(cfg.DeepSeek, reg)
+			if err != nil {
+				logger.Warnf("skipping deepseek: %v", err)
+				continue
+			}
+			backends = append(backends, fallback.Backend{Name: name, Model: ds})
+		case "openrouter":
+			backends = append(backends, fallback.Backend{Name: name, Model: or})
+		case "ollama":
+			backends = append(backends, fallback.Backend{Name: name, Model: ollama.NewClient(cfg.Ollama, reg)})
+		default:
+			return nil, fmt.Errorf("unknown chat backend: %s", name)
+		}
+	}
+	if len(backends) == 0 {
+		return nil, fallback.ErrNoBackends
+	}
+	return fallback.New(cfg.Fallback.Threshold, cfg.Fallback.Cooldown, backends...), nil
+}
+
+// newCommands - команды, которые бот понимает в любом режиме
+func newCommands(outbox *twitch.Outbox, reg *prompts.Registry) *commands.Router {
+	router := commands.NewRouter("!", func(channel, parentMsgID, user, text string) {
+		outbox.Command(channel, parentMsgID, user, text)
+	})
+	router.MustRegister(commands.Command{
+		Name:        "ping",
+		Aliases:     []string{"пинг"},
+		Description: "проверить что бот живой",
+		Usage:       "!ping",
+		Handler: func(ctx *commands.Context) error {
+			ctx.Reply("pong")
+			return nil
+		},
+	})
+	router.MustRegister(commands.Command{
+		Name:        "characters",
+		Aliases:     []string{"personas"},
+		Description: "какие персонажи загружены из prompts.yaml",
+		Usage:       "!characters",
+		Level:       commands.Moderator,
+		Handler: func(ctx *commands.Context) error {
+			ctx.Reply(strings.Join(reg.Names(), ", "))
+			return nil
+		},
+	})
+	return router
+}
+
 func testGetEvents(streamer string) {
-	db, err := database.New()
+	db, err := openDB()
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -264,36 +365,49 @@ func testGetEvents(streamer string) {
 }
 
 func testMonitorChatEventsWithImages(WithImages bool, channels ...string) {
-	err := openrouter.LoadCharacters()
+	tw, err := twitch.NewClient()
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	tw, err := twitch.NewClient()
+	db, err := openDB()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	cfg, err := config.Load()
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	db, err := database.New()
+	reg, err := prompts.Load(cfg.Prompts.Path)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	gmn, err := openrouter.GetNewClient(false)
+	gmn, err := openrouter.GetNewClient(cfg.OpenRouter, reg, false)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
+	fetch.SetDefault(cfg.Fetch)
+	outbox := twitch.NewOutbox(tw.TWClient, cfg.Outbox)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	client := client.NewClientBuilder().
 		WithDB(db).
+		WithRetention(cfg.Retention).
 		WithTwitch(tw).
 		WithContext(ctx, cancel).
-		WithGemeni(gmn).
+		WithVision(gmn).
+		WithPrompts(reg, cfg.Prompts.ReloadInterval).
+		WithOutbox(outbox).
+		WithCommands(newCommands(outbox, reg)).
 		Build()
 
 	// эта в горутине, тк она блокирующая
@@ -335,7 +449,7 @@ func testMonitorChatEvents(channels ...string) {
 		return
 	}
 
-	db, err := database.New()
+	db, err := openDB()
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -358,7 +472,7 @@ func testMonitorChatEvents(channels ...string) {
 }
 
 func testSqlite() {
-	db, err := database.New()
+	db, err := openDB()
 	if err != nil {
 		fmt.Println(err)
 		return
@@ -407,7 +521,12 @@ func testTimeline() {
 }
 
 func testLoadCharacters() {
-	openrouter.LoadCharacters()
+	reg, err := prompts.Load(prompts.DefaultConfig().Path)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(reg.Names())
 }
 
 func testGetImageAndDescribe() {
@@ -425,11 +544,11 @@ func testGetImageAndDescribe() {
 	if err != nil {
 		logger.Info("error getting image:" + err.Error())
 	}
-	resp, err := ollama.DescribeImageBytes(data)
+	resp, err := ollama.NewClient(ollama.DefaultConfig(), nil).DescribeImage(context.Background(), ai.ImageRequest{Data: data})
 	if err != nil {
 		logger.Info("ollama error:" + err.Error())
 	}
-	fmt.Printf("image url:%s\ndescription: %s\n", u, resp)
+	fmt.Printf("image url:%s\ndescription: %s\n", u, resp.Text)
 }
 
 func testFindUrl() {
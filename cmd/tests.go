@@ -1,9 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"time"
 
+	twitchirc "github.com/gempir/go-twitch-irc/v4"
+	"github.com/godovasik/dawgobot/internal/ai"
+	"github.com/godovasik/dawgobot/internal/ai/deepseek"
+	"github.com/godovasik/dawgobot/internal/ai/fallback"
+	"github.com/godovasik/dawgobot/internal/ai/ollama"
+	"github.com/godovasik/dawgobot/internal/ai/prompts"
+	"github.com/godovasik/dawgobot/internal/commands"
 	"github.com/godovasik/dawgobot/internal/timeline"
 )
 
@@ -269,3 +282,167 @@ func testChannelStress() {
 // 		return
 // 	}
 // }
+
+// Тест fallback на фейковых серверах: openai-совместимый сервер всегда отдает 429,
+// "олама" отвечает. Клиенты настоящие, просто смотрят на httptest через конфиг.
+func testFallback() {
+	fmt.Println("=== Test Fallback Chain ===")
+	reg := prompts.FromMap(map[string]string{"test": "ты тестовый бот"})
+
+	// хендлер крутится в горутине сервера, поэтому счетчик атомарный
+	var limited atomic.Int32
+	rateLimited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limited.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"message":"rate limited","type":"rate_limit"}}`)
+	}))
+	defer rateLimited.Close()
+
+	fakeOllama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"model":"fake","message":{"role":"assistant","content":"ok"},"done":true,"prompt_eval_count":3,"eval_count":1}`)
+	}))
+	defer fakeOllama.Close()
+
+	ds, err := deepseek.NewClient(deepseek.Config{BaseURL: rateLimited.URL, Model: "fake", Token: "test"}, reg)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	ol := ollama.NewClient(ollama.Config{BaseURL: fakeOllama.URL, ChatModel: "fake"}, reg)
+
+	chain := fallback.New(2, 500*time.Millisecond,
+		fallback.Backend{Name: "deepseek", Model: ds},
+		fallback.Backend{Name: "ollama", Model: ol},
+	)
+
+	ctx := context.Background()
+	req := ai.ChatRequest{Character: "test", Message: "hi"}
+	for i := 0; i < 4; i++ {
+		resp, err := chain.Chat(ctx, req)
+		fmt.Printf("request %d: text=%q backend=%s tokens=%d err=%v\n", i, resp.Text, resp.Backend, resp.Usage.TotalTokens, err)
+	}
+	// после двух 429 предохранитель сработал, deepseek больше не дергаем
+	fmt.Printf("deepseek was called %d times (want 2)\n", limited.Load())
+
+	time.Sleep(600 * time.Millisecond)
+	chain.Chat(ctx, req)
+	fmt.Printf("after cooldown deepseek was called %d times (want 3)\n", limited.Load())
+	fmt.Println("stats:", chain.Stats())
+
+	// пробный запрос отменили: предохранитель не должен остаться занятым навсегда
+	var slowCalls atomic.Int32
+	stop := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slowCalls.Add(1) == 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error":{"message":"rate limited","type":"rate_limit"}}`)
+			return
+		}
+		// висим, пока клиент не отвалится или тест не закончится
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	defer slow.Close()
+	defer close(stop) // раньше Close, иначе он ждет висящий хендлер
+
+	slowDS, err := deepseek.NewClient(deepseek.Config{BaseURL: slow.URL, Model: "fake", Token: "test"}, reg)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	probeChain := fallback.New(1, 100*time.Millisecond, fallback.Backend{Name: "slow", Model: slowDS})
+	probeChain.Chat(ctx, req)
+	time.Sleep(150 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		probeCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		_, err = probeChain.Chat(probeCtx, req)
+		cancel()
+		fmt.Printf("cancelled probe %d: err=%v\n", i, err)
+	}
+	fmt.Printf("slow backend was called %d times (want 3)\n", slowCalls.Load())
+	fmt.Println()
+}
+
+// Тест перезагрузки промптов: валидный файл подхватывается, битый игнорируется
+func testPromptsReload() {
+	fmt.Println("=== Test Prompts Reload ===")
+	path := filepath.Join(os.TempDir(), "dawgobot_prompts_test.yaml")
+	defer os.Remove(path)
+
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			fmt.Println(err)
+		}
+	}
+	write("characters:\n  describeImageShort: a\n  image: b\n")
+
+	reg, err := prompts.Load(path)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reg.Watch(ctx, 50*time.Millisecond, func(names []string, err error) {
+		fmt.Printf("reload: names=%v err=%v\n", names, err)
+	})
+
+	// mtime у некоторых фс с точностью до секунды
+	time.Sleep(1100 * time.Millisecond)
+	write("characters:\n  describeImageShort: a\n  image: b\n  mention: c\n")
+	time.Sleep(1100 * time.Millisecond)
+	write("characters: [this is not a map")
+	time.Sleep(300 * time.Millisecond)
+
+	fmt.Println("has mention after broken file:", reg.Has("mention"))
+	fmt.Println()
+}
+
+// Тест команд: алиасы, кавычки, права по бейджам, !help
+func testCommands() {
+	fmt.Println("=== Test Commands ===")
+	router := commands.NewRouter("!", func(channel, parentMsgID, user, text string) {
+		fmt.Printf("  -> [%s] %s\n", channel, text)
+	})
+	router.MustRegister(commands.Command{
+		Name:    "say",
+		Aliases: []string{"скажи"},
+		Usage:   `!say "текст"`,
+		Level:   commands.Moderator,
+		Handler: func(ctx *commands.Context) error {
+			if len(ctx.Args) != 1 {
+				return commands.ErrUsage
+			}
+			ctx.Reply(ctx.Args[0])
+			return nil
+		},
+	})
+
+	msg := func(text string, badges map[string]int) twitchirc.PrivateMessage {
+		return twitchirc.PrivateMessage{
+			Message: text,
+			Channel: "forsen",
+			User:    twitchirc.User{Name: "user", Badges: badges},
+		}
+	}
+
+	cases := []twitchirc.PrivateMessage{
+		msg("!help", nil),
+		msg("!help", map[string]int{"moderator": 1}),
+		msg(`!скажи "привет чат"`, map[string]int{"moderator": 1}),
+		msg("!say a b", map[string]int{"broadcaster": 1}),
+		msg("!say hi", map[string]int{"subscriber": 12}),
+		msg("!help say", map[string]int{"vip": 1}),
+		msg("просто сообщение", nil),
+	}
+	for _, m := range cases {
+		fmt.Printf("%q badges=%v\n", m.Message, m.User.Badges)
+		fmt.Println("  handled:", router.Handle(m))
+	}
+	fmt.Println()
+}
@@ -0,0 +1,87 @@
+package ai
+
+// общие интерфейсы для всех нейронок.
+// client работает только с ними, поэтому модель можно поменять
+// не трогая client.go - достаточно передать другую реализацию в билдер.
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// персонаж, которым описываем картинки, если в запросе не указан другой
+const DefaultImageCharacter = "describeImageShort"
+
+var (
+	ErrNoChoices        = errors.New("model returned no choices")
+	ErrUnknownCharacter = errors.New("no such character")
+)
+
+// Usage - сколько токенов съел запрос
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+}
+
+// Response - ответ модели вместе с расходом токенов
+type Response struct {
+	Text    string
+	Model   string
+	Backend string // какой бэкенд реально ответил, заполняет fallback.Chain
+	Usage   Usage
+}
+
+// ChatRequest - текстовый запрос, Character это имя персонажа из prompts.yaml.
+// Vars подставляются в шаблон персонажа (обычно *prompts.Vars).
+// Model переопределяет модель из конфига провайдера.
+// Models - то же по имени бэкенда, fallback.Chain кладет в Model только модель того, кого спрашивает.
+type ChatRequest struct {
+	Character string
+	Message   string
+	Vars      any
+	Model     string
+	Models    map[string]string
+}
+
+// ImageRequest - запрос на описание картинки.
+// Если Data не пустой, модели отправляются байты, иначе ссылка.
+type ImageRequest struct {
+	URL       string
+	Data      []byte
+	Character string // по умолчанию DefaultImageCharacter
+	Vars      any
+	Frames    int // >0 - в Data склейка стольких кадров гифки, см. ollama.AnimationSheet
+}
+
+// HTTPError - ответ апи с плохим статусом, для тех клиентов что ходят в апи руками
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("HTTP ошибка: %d: %s", e.StatusCode, e.Body)
+}
+
+type ChatModel interface {
+	Chat(ctx context.Context, req ChatRequest) (Response, error)
+}
+
+type VisionModel interface {
+	DescribeImage(ctx context.Context, req ImageRequest) (Response, error)
+}
+
+// AnimationHint - приписка к промпту, если модели отправляется склейка кадров, а не картинка
+func AnimationHint(frames int) string {
+	return fmt.Sprintf("Это %d кадров из анимации (гифки), по порядку слева направо и сверху вниз. Опиши, что в ней происходит.", frames)
+}
+
+// DataURL кодирует картинку в data: url, его понимают openai-совместимые api
+func DataURL(data []byte) string {
+	mime := http.DetectContentType(data)
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
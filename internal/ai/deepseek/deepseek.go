@@ -1,67 +1,57 @@
 package deepseek
 
 import (
+	"cmp"
 	"context"
 	"fmt"
-	"os"
 	"time"
 
+	"github.com/godovasik/dawgobot/internal/ai"
+	"github.com/godovasik/dawgobot/internal/ai/prompts"
 	"github.com/godovasik/dawgobot/logger"
 	"github.com/sashabaranov/go-openai"
-	"gopkg.in/yaml.v3"
 )
 
-var Characters map[string]string
+type Config struct {
+	BaseURL string `yaml:"base_url"`
+	Model   string `yaml:"model"`
+	Token   string `yaml:"token"` // обычно берется из DEEPSEEK_TOKEN
+}
 
-type CharactersConfig struct {
-	Characters map[string]string `yaml:"characters"`
+func DefaultConfig() Config {
+	return Config{
+		BaseURL: "https://api.deepseek.com",
+		Model:   "deepseek-chat",
+	}
 }
 
 type Client struct {
 	OpenaiCli *openai.Client
+	model     string
+	prompts   *prompts.Registry
 }
 
-func LoadCharacters() error {
-	// Читаем YAML файл
-	data, err := os.ReadFile("internal/ai/prompts.yaml")
-	if err != nil {
-		return fmt.Errorf("cant read file: %w", err)
-	}
-
-	// Парсим YAML
-	var config CharactersConfig
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
-		return fmt.Errorf("cant unmarshal charagers: %w", err)
-	}
-
-	// Присваиваем загруженных персонажей
-	Characters = config.Characters
-
-	logger.Infof("Загружено %d персонажей", len(Characters))
-	return nil
-}
-
-func NewClient() (*Client, error) {
-	apiKey := os.Getenv("DEEPSEEK_TOKEN")
-	if apiKey == "" {
+// NewClient работает с любым openai-совместимым апи (vLLM, llama.cpp server),
+// достаточно поменять BaseURL и Model
+func NewClient(cfg Config, reg *prompts.Registry) (*Client, error) {
+	if cfg.Token == "" {
 		return nil, fmt.Errorf("DEEPSEEK_TOKEN not set")
 	}
 
-	config := openai.DefaultConfig(apiKey)
-	config.BaseURL = "https://api.deepseek.com"
+	config := openai.DefaultConfig(cfg.Token)
+	config.BaseURL = cfg.BaseURL
 	openaiCli := openai.NewClientWithConfig(config)
-	logger.Info("deepseek initialized")
-	return &Client{OpenaiCli: openaiCli}, nil
+	logger.Infof("deepseek initialized: %s, model %s", cfg.BaseURL, cfg.Model)
+	return &Client{OpenaiCli: openaiCli, model: cfg.Model, prompts: reg}, nil
 }
 
-func (c *Client) GetResponse(character, message string) (string, error) {
-	prompt, ok := Characters[character]
-	if !ok {
-		return "", fmt.Errorf("no such character")
+func (c *Client) Chat(ctx context.Context, r ai.ChatRequest) (ai.Response, error) {
+	prompt, err := c.prompts.Render(r.Character, r.Vars)
+	if err != nil {
+		return ai.Response{}, err
 	}
 	req := openai.ChatCompletionRequest{
-		Model: "deepseek-chat",
+		Model: cmp.Or(r.Model, c.model),
 		Messages: []openai.ChatCompletionMessage{
 			{
 				Role:    openai.ChatMessageRoleSystem,
@@ -69,21 +59,32 @@ func (c *Client) GetResponse(character, message string) (string, error) {
 			},
 			{
 				Role:    openai.ChatMessageRoleUser,
-				Content: message,
+				Content: r.Message,
 			},
 		},
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*20)
 	defer cancel()
 
 	logger.Info("оптравляем запрос дипсику...")
 	start := time.Now()
 	resp, err := c.OpenaiCli.CreateChatCompletion(ctx, req)
 	if err != nil {
-		return "", fmt.Errorf("ошибка при отправке запроса: %v", err)
+		return ai.Response{}, fmt.Errorf("ошибка при отправке запроса: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return ai.Response{}, ai.ErrNoChoices
 	}
 
 	logger.Infof("реквест занял времяни: %v", time.Since(start))
-	return fmt.Sprint(resp.Choices[0].Message.Content), nil
+	return ai.Response{
+		Text:  resp.Choices[0].Message.Content,
+		Model: resp.Model,
+		Usage: ai.Usage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+			TotalTokens:      resp.Usage.TotalTokens,
+		},
+	}, nil
 }
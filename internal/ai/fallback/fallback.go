@@ -0,0 +1,208 @@
+package fallback
+
+// Chain - составной ChatModel: пробует бэкенды по порядку, пока кто-нибудь не ответит.
+// Если бэкенд подряд несколько раз отвечает 429/5xx (или вообще не отвечает),
+// на него ставится предохранитель и он пропускается до конца cooldown.
+// После cooldown пропускаем один пробный запрос: ответил - снимаем предохранитель,
+// упал - снова выключаем на cooldown.
+//
+// как использовать:
+//
+// chain := fallback.New(3, 2*time.Minute,
+// 	fallback.Backend{Name: "deepseek", Model: ds},
+// 	fallback.Backend{Name: "openrouter", Model: or},
+// 	fallback.Backend{Name: "ollama", Model: ol},
+// )
+// resp, err := chain.Chat(ctx, req) // resp.Backend - кто ответил
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/godovasik/dawgobot/internal/ai"
+	"github.com/godovasik/dawgobot/logger"
+	"github.com/sashabaranov/go-openai"
+)
+
+var (
+	ErrNoBackends = errors.New("no backends configured")
+	ErrAllOpen    = errors.New("all backends are cooling down")
+)
+
+type Backend struct {
+	Name  string
+	Model ai.ChatModel
+}
+
+// breaker - предохранитель для одного бэкенда
+type breaker struct {
+	Backend
+
+	mu        sync.Mutex
+	failures  int // сколько раз подряд упал
+	openUntil time.Time
+	probing   bool // после cooldown уже пустили пробный запрос
+	answered  int
+}
+
+type Chain struct {
+	backends  []*breaker
+	threshold int
+	cooldown  time.Duration
+}
+
+func New(threshold int, cooldown time.Duration, backends ...Backend) *Chain {
+	if threshold < 1 {
+		threshold = 1
+	}
+	c := &Chain{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+	for _, b := range backends {
+		c.backends = append(c.backends, &breaker{Backend: b})
+	}
+	return c
+}
+
+func (c *Chain) Chat(ctx context.Context, req ai.ChatRequest) (ai.Response, error) {
+	if len(c.backends) == 0 {
+		return ai.Response{}, ErrNoBackends
+	}
+
+	var errs []error
+	for _, b := range c.backends {
+		if !c.allow(b) {
+			logger.Debugf("backend %s is cooling down, skip", b.Name)
+			continue
+		}
+
+		// id модели одного провайдера другому непонятен, поэтому у каждого бэкенда своя
+		backendReq := req
+		backendReq.Model = cmp.Or(req.Models[b.Name], req.Model)
+		backendReq.Models = nil
+
+		resp, err := b.Model.Chat(ctx, backendReq)
+		if err == nil {
+			c.success(b)
+			resp.Backend = b.Name
+			logger.Debugf("answered by %s", b.Name)
+			return resp, nil
+		}
+
+		// если нас самих отменили - дальше пробовать смысла нет.
+		// бэкенд тут не виноват, но пробный запрос надо отпустить, иначе он занят навсегда
+		if ctx.Err() != nil {
+			c.release(b)
+			return ai.Response{}, ctx.Err()
+		}
+
+		logger.Warnf("backend %s failed: %v", b.Name, err)
+		errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+		c.failure(b, IsOverloaded(err))
+	}
+
+	if len(errs) == 0 {
+		return ai.Response{}, ErrAllOpen
+	}
+	return ai.Response{}, fmt.Errorf("all backends failed: %w", errors.Join(errs...))
+}
+
+func (c *Chain) allow(b *breaker) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < c.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) {
+		return false
+	}
+	// cooldown прошел, пускаем только один пробный запрос
+	if b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (c *Chain) success(b *breaker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures >= c.threshold {
+		logger.Infof("backend %s is back", b.Name)
+	}
+	b.failures = 0
+	b.probing = false
+	b.answered++
+}
+
+// release отпускает пробный запрос, не считая его ни успехом, ни падением
+func (c *Chain) release(b *breaker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// failure считает только перегрузки, остальные ошибки (400, неизвестный персонаж)
+// бэкенд не выключают
+func (c *Chain) failure(b *breaker, overloaded bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !overloaded {
+		return
+	}
+	b.failures++
+	if b.failures >= c.threshold {
+		b.openUntil = time.Now().Add(c.cooldown)
+		logger.Warnf("backend %s failed %d times in a row, disabled until %s",
+			b.Name, b.failures, b.openUntil.Format("15:04:05"))
+	}
+}
+
+// Stats возвращает сколько раз ответил каждый бэкенд
+func (c *Chain) Stats() map[string]int {
+	stats := make(map[string]int, len(c.backends))
+	for _, b := range c.backends {
+		b.mu.Lock()
+		stats[b.Name] = b.answered
+		b.mu.Unlock()
+	}
+	return stats
+}
+
+// IsOverloaded - ошибка из-за которой бэкенд стоит ненадолго выключить:
+// rate limit, 5xx или сетевая ошибка
+func IsOverloaded(err error) bool {
+	if code := statusCode(err); code != 0 {
+		return code == http.StatusTooManyRequests || code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func statusCode(err error) int {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode
+	}
+	var httpErr *ai.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode
+	}
+	return 0
+}
@@ -308,5 +308,3 @@ func main() {
 
 	fmt.Printf("Generated caption with options: %s\n", captionWithOptions)
 }
-
-func test()
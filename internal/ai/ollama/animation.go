@@ -0,0 +1,471 @@
+package ollama
+
+// гифки и анимированные webp. image.Decode отдает только первый кадр гифки, а анимированный webp
+// golang.org/x/image/webp не читает вообще, поэтому кадры собираются тут: gif через gif.DecodeAll,
+// webp - руками по ANMF чанкам, каждый кадр заворачивается в отдельный статичный webp.
+// из анимации берется несколько кадров равномерно по времени и склеивается в одну картинку-сетку,
+// ее и видит vision модель.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"math"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// MaxAnimationFrames - сколько кадров брать из анимации по умолчанию
+const MaxAnimationFrames = 6
+
+// лимиты проверяются по заголовкам до того как что-то декодируется:
+// гифка на пару килобайт может объявить тысячи кадров 60000x60000
+const (
+	maxCanvasPixels  = 2048 * 2048 // холст анимации
+	maxTotalPixels   = 64 << 20    // сумма площадей всех кадров, столько байт держит gif.DecodeAll
+	maxAnimationSize = 1000        // кадров в файле
+)
+
+const (
+	sheetMaxSize = 1024                   // сторона склейки, как в ResizeImageBytes
+	sheetGap     = 4                      // полоска между кадрами
+	defaultDelay = 100 * time.Millisecond // браузеры так показывают кадры с нулевой задержкой
+)
+
+var errNotAnimated = errors.New("not an animation")
+
+// AnimationSheet - если data анимированная gif или webp, склейка из maxFrames кадров в jpeg
+// и сколько кадров в нее вошло. для статичных картинок data как есть и 0
+func AnimationSheet(data []byte, maxFrames int) ([]byte, int, error) {
+	if maxFrames < 2 {
+		return data, 0, nil
+	}
+
+	var cells []image.Image
+	var layout sheetLayout
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		cells, layout, err = gifKeyframes(data, maxFrames)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		cells, layout, err = webpKeyframes(data, maxFrames)
+	default:
+		return data, 0, nil
+	}
+	if errors.Is(err, errNotAnimated) || (err == nil && len(cells) < 2) {
+		return data, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sheet, err := layout.render(cells)
+	if err != nil {
+		return nil, 0, err
+	}
+	return sheet, len(cells), nil
+}
+
+// checkAnimationSize - холст, число кадров и их суммарная площадь в пределах лимитов
+func checkAnimationSize(format string, width, height, frames, totalPixels int) error {
+	switch {
+	case width <= 0 || height <= 0:
+		return fmt.Errorf("%s has no size", format)
+	case width*height > maxCanvasPixels:
+		return fmt.Errorf("%s is too large: %dx%d", format, width, height)
+	case frames > maxAnimationSize:
+		return fmt.Errorf("%s has too many frames: %d", format, frames)
+	case totalPixels > maxTotalPixels:
+		return fmt.Errorf("%s frames are too large: %d pixels", format, totalPixels)
+	}
+	return nil
+}
+
+// pickFrames - индексы кадров, которые видны в моменты 0, T/n, 2T/n..., T - длина анимации
+func pickFrames(delays []time.Duration, n int) map[int]bool {
+	var total time.Duration
+	for i, d := range delays {
+		if d <= 0 {
+			delays[i] = defaultDelay
+		}
+		total += delays[i]
+	}
+
+	picked := make(map[int]bool, n)
+	var start time.Duration
+	k := 0
+	for i, d := range delays {
+		for k < n && total*time.Duration(k)/time.Duration(n) < start+d {
+			picked[i] = true // длинный кадр может закрыть несколько моментов, берется один раз
+			k++
+		}
+		start += d
+	}
+	return picked
+}
+
+func gifKeyframes(data []byte, maxFrames int) ([]image.Image, sheetLayout, error) {
+	cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, sheetLayout{}, err
+	}
+	// gif.DecodeAll держит все кадры разом, а кадр не больше холста (это проверяет сам декодер),
+	// поэтому хватает заголовков: размер холста и площади кадров из image descriptor
+	frameCount, totalPixels, err := gifFrameAreas(data)
+	if err != nil {
+		return nil, sheetLayout{}, err
+	}
+	if frameCount < 2 {
+		return nil, sheetLayout{}, errNotAnimated
+	}
+	if err := checkAnimationSize("gif", cfg.Width, cfg.Height, frameCount, totalPixels); err != nil {
+		return nil, sheetLayout{}, err
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, sheetLayout{}, err
+	}
+
+	delays := make([]time.Duration, len(g.Image))
+	for i, d := range g.Delay {
+		delays[i] = time.Duration(d) * 10 * time.Millisecond
+	}
+	picked := pickFrames(delays, maxFrames)
+	layout := newSheetLayout(len(picked), cfg.Width, cfg.Height)
+
+	// кадры гифки бывают кусочками поверх предыдущих, поэтому рисуем все подряд на холсте
+	canvas := image.NewRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+	var cells []image.Image
+	for i, frame := range g.Image {
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if picked[i] {
+			cells = append(cells, layout.cell(canvas))
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return cells, layout, nil
+}
+
+// gifFrameAreas проходит по блокам гифки без декодирования: сколько кадров и их суммарная площадь
+func gifFrameAreas(data []byte) (frames, pixels int, err error) {
+	errBad := errors.New("gif: bad block structure")
+	if len(data) < 13 {
+		return 0, 0, errBad
+	}
+	pos := 13 // заголовок и logical screen descriptor
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1) // глобальная палитра
+	}
+
+	// skipSubBlocks - данные в гифке идут кусками: байт длины, данные, в конце 0
+	skipSubBlocks := func() error {
+		for {
+			if pos >= len(data) {
+				return errBad
+			}
+			size := int(data[pos])
+			pos += 1 + size
+			if size == 0 {
+				return nil
+			}
+		}
+	}
+
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension: метка и подблоки
+			pos += 2
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+		case 0x2C: // image descriptor
+			if pos+10 > len(data) {
+				return 0, 0, errBad
+			}
+			width := int(binary.LittleEndian.Uint16(data[pos+5:]))
+			height := int(binary.LittleEndian.Uint16(data[pos+7:]))
+			packed := data[pos+9]
+			pos += 10
+			if packed&0x80 != 0 {
+				pos += 3 << (packed&0x07 + 1) // локальная палитра
+			}
+			pos++ // минимальный размер кода lzw
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+			frames++
+			pixels += width * height
+			if frames > maxAnimationSize || pixels > maxTotalPixels {
+				return frames, pixels, nil // дальше считать незачем, и так не пройдет
+			}
+		case 0x3B: // конец файла
+			return frames, pixels, nil
+		default:
+			return 0, 0, errBad
+		}
+	}
+	return frames, pixels, nil
+}
+
+// webpFrame - ANMF чанк: где рисовать кадр, сколько держать и как смешивать
+type webpFrame struct {
+	rect     image.Rectangle
+	delay    time.Duration
+	noBlend  bool // рисовать поверх без альфы
+	dispose  bool // после показа стереть свой прямоугольник
+	alph     []byte
+	bitmap   []byte // VP8 или VP8L
+	lossless bool
+}
+
+func webpKeyframes(data []byte, maxFrames int) ([]image.Image, sheetLayout, error) {
+	var canvasW, canvasH int
+	var frames []webpFrame
+	animated := false
+
+	// здесь только режутся чанки, декодируется все уже после проверки размеров
+	err := riffChunks(data[12:], func(id string, payload []byte) error {
+		switch id {
+		case "VP8X":
+			if len(payload) < 10 {
+				return errors.New("webp: bad VP8X chunk")
+			}
+			animated = payload[0]&0x02 != 0
+			canvasW = int(uint24(payload[4:])) + 1
+			canvasH = int(uint24(payload[7:])) + 1
+		case "ANMF":
+			frame, err := parseANMF(payload)
+			if err != nil {
+				return err
+			}
+			frames = append(frames, frame)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, sheetLayout{}, err
+	}
+	if !animated || len(frames) < 2 {
+		return nil, sheetLayout{}, errNotAnimated
+	}
+
+	canvasRect := image.Rect(0, 0, canvasW, canvasH)
+	totalPixels := 0
+	for i, f := range frames {
+		if !f.rect.In(canvasRect) {
+			return nil, sheetLayout{}, fmt.Errorf("webp frame %d is out of canvas", i)
+		}
+		totalPixels += f.rect.Dx() * f.rect.Dy()
+	}
+	if err := checkAnimationSize("webp", canvasW, canvasH, len(frames), totalPixels); err != nil {
+		return nil, sheetLayout{}, err
+	}
+
+	delays := make([]time.Duration, len(frames))
+	for i, f := range frames {
+		delays[i] = f.delay
+	}
+	picked := pickFrames(delays, maxFrames)
+	layout := newSheetLayout(len(picked), canvasW, canvasH)
+
+	canvas := image.NewRGBA(canvasRect)
+	var cells []image.Image
+	for i, f := range frames {
+		still := f.still()
+		// размер в битстриме VP8 может не совпадать с ANMF, а память выделяется по нему
+		cfg, err := webp.DecodeConfig(bytes.NewReader(still))
+		if err != nil {
+			return nil, sheetLayout{}, fmt.Errorf("webp frame %d: %w", i, err)
+		}
+		if cfg.Width != f.rect.Dx() || cfg.Height != f.rect.Dy() {
+			return nil, sheetLayout{}, fmt.Errorf("webp frame %d is %dx%d, expected %dx%d",
+				i, cfg.Width, cfg.Height, f.rect.Dx(), f.rect.Dy())
+		}
+		img, err := webp.Decode(bytes.NewReader(still))
+		if err != nil {
+			return nil, sheetLayout{}, fmt.Errorf("webp frame %d: %w", i, err)
+		}
+
+		op := draw.Over
+		if f.noBlend {
+			op = draw.Src
+		}
+		draw.Draw(canvas, f.rect, img, img.Bounds().Min, op)
+		if picked[i] {
+			cells = append(cells, layout.cell(canvas))
+		}
+		if f.dispose {
+			draw.Draw(canvas, f.rect, image.Transparent, image.Point{}, draw.Src)
+		}
+	}
+	return cells, layout, nil
+}
+
+func parseANMF(payload []byte) (webpFrame, error) {
+	if len(payload) < 16 {
+		return webpFrame{}, errors.New("webp: bad ANMF chunk")
+	}
+	x, y := int(uint24(payload[0:]))*2, int(uint24(payload[3:]))*2
+	w, h := int(uint24(payload[6:]))+1, int(uint24(payload[9:]))+1
+	frame := webpFrame{
+		rect:    image.Rect(x, y, x+w, y+h),
+		delay:   time.Duration(uint24(payload[12:])) * time.Millisecond,
+		noBlend: payload[15]&0x02 != 0,
+		dispose: payload[15]&0x01 != 0,
+	}
+
+	err := riffChunks(payload[16:], func(id string, sub []byte) error {
+		switch id {
+		case "ALPH":
+			frame.alph = sub
+		case "VP8 ":
+			frame.bitmap = sub
+		case "VP8L":
+			frame.bitmap, frame.lossless = sub, true
+		}
+		return nil
+	})
+	if err != nil {
+		return webpFrame{}, err
+	}
+	if frame.bitmap == nil {
+		return webpFrame{}, errors.New("webp: ANMF without image data")
+	}
+	return frame, nil
+}
+
+// still - кадр как отдельный статичный webp, такой уже читает webp.Decode
+func (f webpFrame) still() []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	switch {
+	case f.lossless:
+		writeChunk(&body, "VP8L", f.bitmap)
+	case f.alph != nil:
+		header := make([]byte, 10)
+		header[0] = 0x10 // есть альфа
+		putUint24(header[4:], uint32(f.rect.Dx()-1))
+		putUint24(header[7:], uint32(f.rect.Dy()-1))
+		writeChunk(&body, "VP8X", header)
+		writeChunk(&body, "ALPH", f.alph)
+		writeChunk(&body, "VP8 ", f.bitmap)
+	default:
+		writeChunk(&body, "VP8 ", f.bitmap)
+	}
+
+	var out bytes.Buffer
+	writeChunk(&out, "RIFF", body.Bytes())
+	return out.Bytes()
+}
+
+// riffChunks проходит по чанкам подряд: fourcc, длина little endian, данные, выравнивание до четного
+func riffChunks(data []byte, fn func(id string, payload []byte) error) error {
+	for len(data) >= 8 {
+		id := string(data[:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[8:]
+		if size < 0 || size > len(data) {
+			return fmt.Errorf("webp: chunk %q is truncated", id)
+		}
+		if err := fn(id, data[:size]); err != nil {
+			return err
+		}
+		data = data[min(size+size%2, len(data)):]
+	}
+	return nil
+}
+
+func writeChunk(buf *bytes.Buffer, id string, payload []byte) {
+	buf.WriteString(id)
+	binary.Write(buf, binary.LittleEndian, uint32(len(payload)))
+	buf.Write(payload)
+	if len(payload)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+	return clone
+}
+
+// sheetLayout - сетка склейки: кадры слева направо, сверху вниз, вся склейка не больше sheetMaxSize.
+// размер ячейки известен заранее, поэтому кадр уменьшается сразу, как только выбран
+type sheetLayout struct {
+	cols, rows   int
+	cellW, cellH int
+}
+
+func newSheetLayout(frames, width, height int) sheetLayout {
+	cols := max(int(math.Ceil(math.Sqrt(float64(frames)))), 1)
+	rows := max((frames+cols-1)/cols, 1)
+
+	scale := math.Min(
+		float64(sheetMaxSize-sheetGap*(cols-1))/float64(cols*width),
+		float64(sheetMaxSize-sheetGap*(rows-1))/float64(rows*height),
+	)
+	scale = math.Min(scale, 1) // мелкие гифки не растягиваем
+	return sheetLayout{
+		cols:  cols,
+		rows:  rows,
+		cellW: max(int(float64(width)*scale), 1),
+		cellH: max(int(float64(height)*scale), 1),
+	}
+}
+
+// cell - уменьшенная копия кадра на белом фоне: прозрачное в jpeg стало бы черным
+func (l sheetLayout) cell(frame image.Image) image.Image {
+	cell := image.NewRGBA(image.Rect(0, 0, l.cellW, l.cellH))
+	draw.Draw(cell, cell.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.BiLinear.Scale(cell, cell.Bounds(), frame, frame.Bounds(), draw.Over, nil)
+	return cell
+}
+
+// render склеивает ячейки в jpeg
+func (l sheetLayout) render(cells []image.Image) ([]byte, error) {
+	sheet := image.NewRGBA(image.Rect(0, 0, l.cols*l.cellW+(l.cols-1)*sheetGap, l.rows*l.cellH+(l.rows-1)*sheetGap))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.Gray{Y: 40}), image.Point{}, draw.Src)
+
+	for i, cell := range cells {
+		x, y := (i%l.cols)*(l.cellW+sheetGap), (i/l.cols)*(l.cellH+sheetGap)
+		draw.Draw(sheet, image.Rect(x, y, x+l.cellW, y+l.cellH), cell, image.Point{}, draw.Src)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, sheet, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -2,23 +2,26 @@ package ollama
 
 import (
 	"bytes"
-	"errors"
+	"context"
 	"fmt"
 	"image"
 	"image/jpeg"
 	"image/png"
-	"io"
-	"net/http"
 	"os"
 	"strings"
 
+	"github.com/godovasik/dawgobot/internal/fetch"
 	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // image.Decode и DecodeConfig для статичных webp
 )
 
 var (
-	ErrNotAnImage = errors.New("it's not an image")
+	ErrNotAnImage = fetch.ErrNotAnImage
 )
 
+// maxImagePixels - картинки больше не декодируем, это уже ~256 мегабайт в RGBA
+const maxImagePixels = 64 << 20
+
 func OpenImage(imagePath string) ([]byte, error) {
 	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
 		return nil, fmt.Errorf("файл %s не найден", imagePath)
@@ -33,11 +36,17 @@ func OpenImage(imagePath string) ([]byte, error) {
 
 // ResizeImageBytes работает напрямую с байтами (более эффективно)
 func ResizeImageBytes(imageBytes []byte) ([]byte, error) {
-	// Создаем reader из байтов
-	reader := bytes.NewReader(imageBytes)
+	// Сначала размер по заголовку: png на пару килобайт может объявить 60000x60000
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(imageBytes))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("image is too large: %dx%d", cfg.Width, cfg.Height)
+	}
 
 	// Декодируем изображение
-	img, format, err := image.Decode(reader)
+	img, format, err := image.Decode(bytes.NewReader(imageBytes))
 	if err != nil {
 		return nil, err
 	}
@@ -89,33 +98,12 @@ func ResizeImageBytes(imageBytes []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// checks if provided url contains an image
+// CheckUrl - HEAD через fetch.Default, картинка ли по мнению сервера
 func CheckUrl(url string) (bool, error) {
-	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
-		url = "https://" + url
-	}
-	resp, err := http.Head(url)
-	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
-
-	contentType := resp.Header.Get("Content-Type")
-	return strings.HasPrefix(contentType, "image/"), nil
+	return fetch.Default.IsImage(context.Background(), url)
 }
 
+// GetImage качает картинку через fetch.Default: без локалки, с лимитом размера и проверкой байтов
 func GetImage(url string) ([]byte, error) {
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	return body, nil
+	return fetch.Default.Image(context.Background(), url)
 }
@@ -2,11 +2,17 @@ package ollama
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
+
+	"github.com/godovasik/dawgobot/internal/ai"
+	"github.com/godovasik/dawgobot/internal/ai/prompts"
 )
 
 type OllamaRequest struct {
@@ -25,66 +31,201 @@ type OllamaResponse struct {
 	LoadDuration       int64  `json:"load_duration,omitempty"`
 	PromptEvalDuration int64  `json:"prompt_eval_duration,omitempty"`
 	EvalDuration       int64  `json:"eval_duration,omitempty"`
+	PromptEvalCount    int    `json:"prompt_eval_count,omitempty"`
+	EvalCount          int    `json:"eval_count,omitempty"`
+}
+
+type OllamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type OllamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+}
+
+type OllamaChatResponse struct {
+	Model           string        `json:"model"`
+	Message         OllamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+	EvalCount       int           `json:"eval_count,omitempty"`
+}
+
+type Config struct {
+	BaseURL     string `yaml:"base_url"`
+	ChatModel   string `yaml:"chat_model"`
+	VisionModel string `yaml:"vision_model"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		BaseURL:     "http://localhost:11434",
+		ChatModel:   "llama3.1",
+		VisionModel: "llava",
+	}
+}
+
+// Client - локальная олама: описывает картинки и может отвечать в чат
+type Client struct {
+	httpClient *http.Client
+	cfg        Config
+	prompts    *prompts.Registry
 }
 
-// DescribeImage принимает путь к изображению и возвращает его описание от LLaVA
+// NewClient принимает реестр персонажей для Chat, если нужны только картинки - можно nil
+func NewClient(cfg Config, reg *prompts.Registry) *Client {
+	return &Client{
+		httpClient: &http.Client{Timeout: 120 * time.Second},
+		cfg:        cfg,
+		prompts:    reg,
+	}
+}
+
+// DescribeImage описывает картинку через LLaVA.
+// Олама не умеет ходить по ссылкам, поэтому если байтов нет - качаем сами.
+func (c *Client) DescribeImage(ctx context.Context, r ai.ImageRequest) (ai.Response, error) {
+	imageBytes := r.Data
+	if len(imageBytes) == 0 {
+		data, err := GetImage(r.URL)
+		if err != nil {
+			return ai.Response{}, fmt.Errorf("cant get image: %w", err)
+		}
+		imageBytes = data
+	}
+	// склейку кадров мог уже сделать вызывающий, тогда Frames > 0
+	frames := r.Frames
+	if frames == 0 {
+		if sheet, n, err := AnimationSheet(imageBytes, MaxAnimationFrames); err == nil {
+			imageBytes, frames = sheet, n
+		}
+	}
 
-func DescribeImageBytes(imageBytes []byte) (string, error) {
 	imageBytes, err := ResizeImageBytes(imageBytes)
 	if err != nil {
-		return "", err
+		return ai.Response{}, err
 	}
 
-	imageData := base64.StdEncoding.EncodeToString(imageBytes)
 	// Читаем и кодируем изображение в base64
+	imageData := base64.StdEncoding.EncodeToString(imageBytes)
+
+	// у llava свой промпт на английском, персонаж из реестра только если его явно попросили
+	prompt := "Describe what you see in this image. Focus on the main elements, setting, and any important details. Be clear and concise."
+	if r.Character != "" && c.prompts != nil {
+		prompt, err = c.prompts.Render(r.Character, r.Vars)
+		if err != nil {
+			return ai.Response{}, err
+		}
+	}
+	if frames > 0 {
+		prompt += "\n" + ai.AnimationHint(frames)
+	}
 
 	// Создаем запрос к Ollama
 	request := OllamaRequest{
-		Model:  "llava",
-		Prompt: "Describe what you see in this image. Focus on the main elements, setting, and any important details. Be clear and concise.",
+		Model:  c.cfg.VisionModel,
+		Prompt: prompt,
 		Images: []string{imageData},
 		Stream: false,
 	}
 
 	// Отправляем запрос
-	response, err := sendRequest(request)
+	response, err := c.sendRequest(ctx, request)
 	if err != nil {
-		return "", fmt.Errorf("ошибка при отправке запроса: %w", err)
+		return ai.Response{}, fmt.Errorf("ошибка при отправке запроса: %w", err)
 	}
 
-	return response.Response, nil
+	return ai.Response{
+		Text:  response.Response,
+		Model: response.Model,
+		Usage: ai.Usage{
+			PromptTokens:     response.PromptEvalCount,
+			CompletionTokens: response.EvalCount,
+			TotalTokens:      response.PromptEvalCount + response.EvalCount,
+		},
+	}, nil
 }
 
-func sendRequest(req OllamaRequest) (*OllamaResponse, error) {
+// Chat отвечает через /api/chat, нужна как запасной вариант если облачные апи легли
+func (c *Client) Chat(ctx context.Context, r ai.ChatRequest) (ai.Response, error) {
+	if c.prompts == nil {
+		return ai.Response{}, fmt.Errorf("%w: %s", ai.ErrUnknownCharacter, r.Character)
+	}
+	prompt, err := c.prompts.Render(r.Character, r.Vars)
+	if err != nil {
+		return ai.Response{}, err
+	}
+
+	request := OllamaChatRequest{
+		Model: cmp.Or(r.Model, c.cfg.ChatModel),
+		Messages: []OllamaMessage{
+			{Role: "system", Content: prompt},
+			{Role: "user", Content: r.Message},
+		},
+		Stream: false,
+	}
+
+	var response OllamaChatResponse
+	if err := c.post(ctx, "/api/chat", request, &response); err != nil {
+		return ai.Response{}, fmt.Errorf("ошибка при отправке запроса: %w", err)
+	}
+
+	return ai.Response{
+		Text:  response.Message.Content,
+		Model: response.Model,
+		Usage: ai.Usage{
+			PromptTokens:     response.PromptEvalCount,
+			CompletionTokens: response.EvalCount,
+			TotalTokens:      response.PromptEvalCount + response.EvalCount,
+		},
+	}, nil
+}
+
+func (c *Client) sendRequest(ctx context.Context, req OllamaRequest) (*OllamaResponse, error) {
+	var ollamaResp OllamaResponse
+	if err := c.post(ctx, "/api/generate", req, &ollamaResp); err != nil {
+		return nil, err
+	}
+	return &ollamaResp, nil
+}
+
+func (c *Client) post(ctx context.Context, path string, req any, out any) error {
 	// Сериализуем запрос в JSON
 	jsonData, err := json.Marshal(req)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка сериализации JSON: %w", err)
+		return fmt.Errorf("ошибка сериализации JSON: %w", err)
 	}
 
 	// Отправляем POST запрос к Ollama API
-	resp, err := http.Post("http://localhost:11434/api/generate", "application/json", bytes.NewBuffer(jsonData))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.cfg.BaseURL+path, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return nil, fmt.Errorf("ошибка HTTP запроса: %w", err)
+		return fmt.Errorf("ошибка создания запроса: %w", err)
 	}
-	defer resp.Body.Close()
+	httpReq.Header.Set("Content-Type", "application/json")
 
-	// Проверяем статус ответа
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP ошибка: %d", resp.StatusCode)
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("ошибка HTTP запроса: %w", err)
 	}
+	defer resp.Body.Close()
 
 	// Читаем тело ответа
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка чтения ответа: %w", err)
+		return fmt.Errorf("ошибка чтения ответа: %w", err)
+	}
+
+	// Проверяем статус ответа
+	if resp.StatusCode != http.StatusOK {
+		return &ai.HTTPError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	// Парсим JSON ответ
-	var ollamaResp OllamaResponse
-	if err := json.Unmarshal(body, &ollamaResp); err != nil {
-		return nil, fmt.Errorf("ошибка парсинга JSON: %w", err)
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("ошибка парсинга JSON: %w", err)
 	}
 
-	return &ollamaResp, nil
+	return nil
 }
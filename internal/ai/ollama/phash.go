@@ -0,0 +1,43 @@
+package ollama
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/bits"
+
+	"golang.org/x/image/draw"
+)
+
+// DHash - перцептивный хеш картинки (difference hash), 64 бита.
+// картинка сжимается до 9x8 в оттенках серого, каждый бит - светлее ли пиксель соседа справа.
+// пережатие, ресайз и другой cdn почти не меняют хеш, сравнивать через HammingDistance
+func DHash(imageBytes []byte) (uint64, error) {
+	img, _, err := image.Decode(bytes.NewReader(imageBytes))
+	if err != nil {
+		return 0, err
+	}
+
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.BiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if grayAt(small, x, y) > grayAt(small, x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// HammingDistance - в скольких битах хеши отличаются, 0 - одна и та же картинка
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func grayAt(img *image.Gray, x, y int) uint8 {
+	return img.At(x, y).(color.Gray).Y
+}
@@ -3,6 +3,7 @@ package openrouter
 import (
 	"context"
 
+	"github.com/godovasik/dawgobot/internal/ai"
 	"github.com/sashabaranov/go-openai"
 )
 
@@ -14,18 +15,33 @@ type ContentPart struct {
 	} `json:"image_url,omitempty"`
 }
 
-func (c *Client) DescribeImageGemeni(ctx context.Context, url string) (string, error) {
-	character := "describeImageShort"
+func (c *Client) DescribeImage(ctx context.Context, r ai.ImageRequest) (ai.Response, error) {
+	character := r.Character
+	if character == "" {
+		character = ai.DefaultImageCharacter
+	}
+	prompt, err := c.prompts.Render(character, r.Vars)
+	if err != nil {
+		return ai.Response{}, err
+	}
+	if r.Frames > 0 {
+		prompt += "\n" + ai.AnimationHint(r.Frames)
+	}
+
+	url := r.URL
+	if len(r.Data) > 0 {
+		url = ai.DataURL(r.Data)
+	}
 
 	req := openai.ChatCompletionRequest{
-		Model: "google/gemini-2.5-flash-lite-preview-06-17",
+		Model: c.visionModel,
 		Messages: []openai.ChatCompletionMessage{
 			{
 				Role: "user",
 				MultiContent: []openai.ChatMessagePart{
 					{
 						Type: openai.ChatMessagePartTypeText,
-						Text: Characters[character],
+						Text: prompt,
 					},
 					{
 						Type: openai.ChatMessagePartTypeImageURL,
@@ -41,7 +57,7 @@ func (c *Client) DescribeImageGemeni(ctx context.Context, url string) (string, e
 
 	resp, err := c.OpenaiCli.CreateChatCompletion(ctx, req)
 	if err != nil {
-		return "", err
+		return ai.Response{}, err
 	}
-	return resp.Choices[0].Message.Content, nil
+	return toResponse(resp)
 }
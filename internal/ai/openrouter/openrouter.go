@@ -1,51 +1,40 @@
 package openrouter
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
-	"os"
 	"time"
 
+	"github.com/godovasik/dawgobot/internal/ai"
+	"github.com/godovasik/dawgobot/internal/ai/prompts"
 	"github.com/godovasik/dawgobot/logger"
 	"github.com/sashabaranov/go-openai"
-	"gopkg.in/yaml.v3"
 )
 
-var Characters map[string]string
+type Config struct {
+	BaseURL     string `yaml:"base_url"`
+	ChatModel   string `yaml:"chat_model"`
+	VisionModel string `yaml:"vision_model"`
+	Token       string `yaml:"token"` // обычно берется из OPENROUTER_TOKEN
+}
 
-type CharactersConfig struct {
-	Characters map[string]string `yaml:"characters"`
+func DefaultConfig() Config {
+	return Config{
+		BaseURL:     "https://openrouter.ai/api/v1",
+		ChatModel:   "deepseek/deepseek-chat-v3-0324:free",
+		VisionModel: "google/gemini-2.5-flash-lite-preview-06-17",
+	}
 }
 
 type Client struct {
 	OpenaiCli *openai.Client
-}
-
-func LoadCharacters() error {
-	// Читаем YAML файл
-	data, err := os.ReadFile("internal/ai/prompts.yaml")
-	if err != nil {
-		return fmt.Errorf("cant read file: %w", err)
-	}
-
-	// Парсим YAML
-	var config CharactersConfig
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
-		return fmt.Errorf("cant unmarshal charagers: %w", err)
-	}
 
-	// Присваиваем загруженных персонажей
-	Characters = config.Characters
-	keys := []string{}
-	for k := range Characters {
-		keys = append(keys, k)
-	}
-
-	logger.Infof("Загружено %d персонажей: %v", len(Characters), keys)
-	return nil
+	chatModel   string
+	visionModel string
+	prompts     *prompts.Registry
 }
 
 type customHeadersTransport struct {
@@ -85,11 +74,9 @@ func (c customHeadersTransport) RoundTrip(req *http.Request) (*http.Response, er
 	return resp, nil
 }
 
-func GetNewClient(logging bool) (*Client, error) {
-	apiKey := os.Getenv("OPENROUTER_TOKEN")
-
-	config := openai.DefaultConfig(apiKey)
-	config.BaseURL = "https://openrouter.ai/api/v1"
+func GetNewClient(cfg Config, reg *prompts.Registry, logging bool) (*Client, error) {
+	config := openai.DefaultConfig(cfg.Token)
+	config.BaseURL = cfg.BaseURL
 
 	// Кастомный HTTP клиент с нужными заголовками
 	config.HTTPClient = &http.Client{
@@ -105,36 +92,54 @@ func GetNewClient(logging bool) (*Client, error) {
 	}
 	OpenaiClient := openai.NewClientWithConfig(config)
 
-	Client := Client{OpenaiClient}
+	Client := Client{
+		OpenaiCli:   OpenaiClient,
+		chatModel:   cfg.ChatModel,
+		visionModel: cfg.VisionModel,
+		prompts:     reg,
+	}
 
-	logger.Info("openrouter initialized")
+	logger.Infof("openrouter initialized: %s, models %s, %s", cfg.BaseURL, cfg.ChatModel, cfg.VisionModel)
 	return &Client, nil
 }
 
-func (c *Client) GenerateResponseDeepseek(character string, str string) (string, error) {
+func (c *Client) Chat(ctx context.Context, r ai.ChatRequest) (ai.Response, error) {
+	prompt, err := c.prompts.Render(r.Character, r.Vars)
+	if err != nil {
+		return ai.Response{}, err
+	}
 	req := openai.ChatCompletionRequest{
-		Model:  "deepseek/deepseek-chat-v3-0324:free",
+		Model:  cmp.Or(r.Model, c.chatModel),
 		Stream: false,
 		Messages: []openai.ChatCompletionMessage{
 			{
 				Role:    "system",
-				Content: Characters[character],
+				Content: prompt,
 			},
 			{
 				Role:    "user",
-				Content: str,
+				Content: r.Message,
 			},
 		},
 	}
-	ctx := context.Background()
 	resp, err := c.OpenaiCli.CreateChatCompletion(ctx, req)
 	if err != nil {
-		return "", err
+		return ai.Response{}, err
 	}
+	return toResponse(resp)
+}
 
-	answer := resp.Choices
-	if len(answer) == 0 {
-		return "", fmt.Errorf("fuck me")
+func toResponse(resp openai.ChatCompletionResponse) (ai.Response, error) {
+	if len(resp.Choices) == 0 {
+		return ai.Response{}, ai.ErrNoChoices
 	}
-	return resp.Choices[0].Message.Content, nil
+	return ai.Response{
+		Text:  resp.Choices[0].Message.Content,
+		Model: resp.Model,
+		Usage: ai.Usage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+			TotalTokens:      resp.Usage.TotalTokens,
+		},
+	}, nil
 }
@@ -0,0 +1,223 @@
+package prompts
+
+// один реестр персонажей на всю программу вместо Characters в каждом пакете.
+// prompts.yaml читается при старте, реестр передается в клиенты нейронок.
+// Watch перечитывает файл на лету: если новый yaml битый - остаются старые персонажи.
+//
+// промпты это text/template, при каждом запросе в них подставляются Vars:
+//
+//	image: >
+//	  Ты сидишь в чате у {{.Streamer}}{{if .Game}}, он играет в {{.Game}}{{end}}.
+//	  Отвечай на языке: {{.Language}}.
+//	  Последние сообщения:
+//	  {{.RecentChat}}
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/godovasik/dawgobot/internal/ai"
+	"github.com/godovasik/dawgobot/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// Required - без этих персонажей бот не работает, проверяются при загрузке
+var Required = []string{ai.DefaultImageCharacter, "image"}
+
+type Config struct {
+	Path           string        `yaml:"path"`
+	ReloadInterval time.Duration `yaml:"reload_interval"` // 0 - не следить за файлом
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Path:           "internal/ai/prompts.yaml",
+		ReloadInterval: 5 * time.Second,
+	}
+}
+
+// Vars - то, что можно использовать в шаблоне промпта
+type Vars struct {
+	Streamer   string // канал, в котором отвечаем
+	Game       string // из twitch.StreamerInfo, пусто если стрим оффлайн
+	Title      string
+	Author     string // кому отвечаем
+	Language   string // на каком языке отвечать в этом канале
+	RecentChat string // последние события канала, через timeline.SprintEvents
+}
+
+type file struct {
+	Characters map[string]string `yaml:"characters"`
+}
+
+type Registry struct {
+	path string
+
+	mu         sync.RWMutex
+	modTime    time.Time
+	characters map[string]*template.Template
+}
+
+// Load читает yaml и проверяет что все Required персонажи на месте
+func Load(path string) (*Registry, error) {
+	r := &Registry{path: path}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Загружено %d персонажей: %v", len(r.characters), r.Names())
+	return r, nil
+}
+
+// reload читает файл и подменяет персонажей только если он распарсился
+func (r *Registry) reload() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return fmt.Errorf("cant read file: %w", err)
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("cant read file: %w", err)
+	}
+
+	characters, err := parse(data)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// время запоминаем даже для битого файла, чтобы не парсить его каждый тик
+	r.modTime = info.ModTime()
+	if err != nil {
+		return err
+	}
+	r.characters = characters
+	return nil
+}
+
+// Watch раз в interval проверяет время изменения файла и перечитывает его.
+// onReload вызывается после каждой попытки: err == nil - персонажи обновились,
+// иначе работаем со старыми. Блокирует до отмены ctx.
+func (r *Registry) Watch(ctx context.Context, interval time.Duration, onReload func(names []string, err error)) {
+	if r.path == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(r.path)
+			if err != nil {
+				logger.Warnf("cant stat %s: %v", r.path, err)
+				continue
+			}
+			r.mu.RLock()
+			unchanged := info.ModTime().Equal(r.modTime)
+			r.mu.RUnlock()
+			if unchanged {
+				continue
+			}
+
+			err = r.reload()
+			if err != nil {
+				logger.Errorf("prompts reload failed, keeping old characters: %v", err)
+			} else {
+				logger.Infof("prompts reloaded: %v", r.Names())
+			}
+			if onReload != nil {
+				onReload(r.Names(), err)
+			}
+		}
+	}
+}
+
+// FromMap собирает реестр без файла, удобно для тестов.
+// Required не проверяются, паникует на кривом шаблоне.
+func FromMap(characters map[string]string) *Registry {
+	templates, err := compile(characters)
+	if err != nil {
+		panic(err)
+	}
+	return &Registry{characters: templates}
+}
+
+func parse(data []byte) (map[string]*template.Template, error) {
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("cant unmarshal characters: %w", err)
+	}
+
+	var missing []string
+	for _, name := range Required {
+		if f.Characters[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required characters: %v", missing)
+	}
+
+	return compile(f.Characters)
+}
+
+func compile(characters map[string]string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(characters))
+	for name, prompt := range characters {
+		// неизвестное поле в шаблоне - ошибка, а не "<no value>" в промпте
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(prompt)
+		if err != nil {
+			return nil, fmt.Errorf("bad template in %s: %w", name, err)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+// Render подставляет data в промпт персонажа. data обычно *Vars, может быть nil.
+func (r *Registry) Render(name string, data any) (string, error) {
+	r.mu.RLock()
+	tmpl, ok := r.characters[name]
+	r.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ai.ErrUnknownCharacter, name)
+	}
+
+	if data == nil {
+		data = Vars{}
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("cant render %s: %w", name, err)
+	}
+	return sb.String(), nil
+}
+
+func (r *Registry) Has(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.characters[name]
+	return ok
+}
+
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.characters))
+	for name := range r.characters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -2,9 +2,12 @@ package client
 
 import (
 	"context"
+	"time"
 
-	"github.com/godovasik/dawgobot/internal/ai/deepseek"
-	"github.com/godovasik/dawgobot/internal/ai/openrouter"
+	"github.com/godovasik/dawgobot/internal/ai"
+	"github.com/godovasik/dawgobot/internal/ai/prompts"
+	"github.com/godovasik/dawgobot/internal/commands"
+	"github.com/godovasik/dawgobot/internal/config"
 	"github.com/godovasik/dawgobot/internal/database"
 	"github.com/godovasik/dawgobot/internal/timeline"
 	tw "github.com/godovasik/dawgobot/internal/twitch"
@@ -29,8 +32,9 @@ func (b *ClientBuilder) WithTwitch(tw *tw.Client) *ClientBuilder {
 	return b
 }
 
-func (b *ClientBuilder) WithDeepseek(ds *deepseek.Client) *ClientBuilder {
-	b.Client.DSClient = ds
+// WithChat принимает любую текстовую модель: deepseek, openrouter, ...
+func (b *ClientBuilder) WithChat(chat ai.ChatModel) *ClientBuilder {
+	b.Client.Chat = chat
 	return b
 }
 
@@ -39,6 +43,12 @@ func (b *ClientBuilder) WithDB(db *database.DB) *ClientBuilder {
 	return b
 }
 
+// WithRetention - монитор будет сам чистить старые события из базы по этим правилам
+func (b *ClientBuilder) WithRetention(cfg database.RetentionConfig) *ClientBuilder {
+	b.Client.retention = cfg
+	return b
+}
+
 func (b *ClientBuilder) WithTimeline(tl *timeline.Timeline) *ClientBuilder {
 	b.Client.Timeline = tl
 	return b
@@ -50,7 +60,33 @@ func (b *ClientBuilder) WithContext(ctx context.Context, cancel context.CancelFu
 	return b
 }
 
-func (b *ClientBuilder) WithGemeni(gmn *openrouter.Client) *ClientBuilder {
-	b.Client.Gemeni = gmn
+// WithVision принимает любую модель, которая умеет описывать картинки
+func (b *ClientBuilder) WithVision(vision ai.VisionModel) *ClientBuilder {
+	b.Client.Vision = vision
+	return b
+}
+
+// WithPrompts - реестр персонажей, за файлом которого клиент будет следить
+func (b *ClientBuilder) WithPrompts(reg *prompts.Registry, reloadInterval time.Duration) *ClientBuilder {
+	b.Client.Prompts = reg
+	b.Client.promptsReload = reloadInterval
+	return b
+}
+
+// WithChannels - персоны и фичи по каналам из конфига
+func (b *ClientBuilder) WithChannels(cfg *config.Config) *ClientBuilder {
+	b.Client.channels = cfg.Channel
+	return b
+}
+
+// WithCommands - роутер !команд, работает вместе с остальными обработчиками чата
+func (b *ClientBuilder) WithCommands(router *commands.Router) *ClientBuilder {
+	b.Client.Commands = router
+	return b
+}
+
+// WithOutbox - через него идут все ответы бота, с кулдаунами и лимитами твича
+func (b *ClientBuilder) WithOutbox(outbox *tw.Outbox) *ClientBuilder {
+	b.Client.Outbox = outbox
 	return b
 }
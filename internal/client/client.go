@@ -4,13 +4,16 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	twitch "github.com/gempir/go-twitch-irc/v4" // костыль пиздец
-	"github.com/godovasik/dawgobot/internal/ai/deepseek"
-	"github.com/godovasik/dawgobot/internal/ai/ollama"
-	"github.com/godovasik/dawgobot/internal/ai/openrouter"
+	"github.com/godovasik/dawgobot/internal/ai"
+	"github.com/godovasik/dawgobot/internal/ai/prompts"
+	"github.com/godovasik/dawgobot/internal/commands"
+	"github.com/godovasik/dawgobot/internal/config"
 	"github.com/godovasik/dawgobot/internal/database"
+	"github.com/godovasik/dawgobot/internal/fetch"
 	"github.com/godovasik/dawgobot/internal/timeline"
 	tw "github.com/godovasik/dawgobot/internal/twitch"
 	"github.com/godovasik/dawgobot/logger"
@@ -23,8 +26,18 @@ type Client struct {
 	Timeline *timeline.Timeline
 	DB       *database.DB
 
-	DSClient *deepseek.Client
-	Gemeni   *openrouter.Client
+	Chat   ai.ChatModel
+	Vision ai.VisionModel
+
+	Prompts       *prompts.Registry
+	promptsReload time.Duration
+	infoCache     streamInfoCache
+
+	channels  func(name string) config.ChannelSettings
+	retention database.RetentionConfig
+
+	Commands *commands.Router
+	Outbox   *tw.Outbox
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -52,7 +65,38 @@ func (c *Client) MonitorChatEvents(WithImages bool, channels ...string) error {
 	}
 
 	// Выбираем обработчик в зависимости от WithImages
-	c.TWClient.TWClient.OnPrivateMessage(c.GetHandleMonitor(eventCh, WithImages))
+	// отвечать в мониторе могут только команды
+	if c.Outbox != nil {
+		go c.Outbox.Run(c.ctx)
+	}
+
+	// команды выполняются, но сообщения с ними все равно логируем
+	handleMonitor := c.GetHandleMonitor(eventCh, WithImages)
+	c.TWClient.TWClient.OnPrivateMessage(func(message twitch.PrivateMessage) {
+		c.handleCommand(message)
+		handleMonitor(message)
+	})
+	c.TWClient.TWClient.OnUserNoticeMessage(c.GetHandleUserNotice(eventCh))
+	c.TWClient.TWClient.OnClearChatMessage(c.GetHandleClearChat(eventCh))
+	c.TWClient.TWClient.OnClearMessage(c.GetHandleClearMessage(eventCh))
+
+	// следим за prompts.yaml, перезагрузку пишем в таймлайн.
+	// канал закрываем только после того как watcher вышел
+	var watchers sync.WaitGroup
+	watchers.Add(1)
+	go func() {
+		defer watchers.Done()
+		c.watchPrompts(func(event timeline.Event) {
+			select {
+			case eventCh <- event:
+			default:
+				logger.Warn("Event channel full, dropping reload event")
+			}
+		}, channels...)
+	}()
+
+	// старые события чистятся прямо во время мониторинга, без правил - ничего не делает
+	go c.DB.RunPruner(c.ctx, c.retention)
 
 	// Запускаем горутину для обработки батчей
 	batchDone := make(chan struct{})
@@ -76,6 +120,7 @@ func (c *Client) MonitorChatEvents(WithImages bool, channels ...string) error {
 		}
 		eventCh <- stopEvent
 	}
+	watchers.Wait()
 	time.Sleep(100 * time.Millisecond)
 	close(eventCh)
 	logger.Info("Event channel closed")
@@ -167,7 +212,7 @@ func (c *Client) processImages(eventCh chan timeline.Event, event timeline.Event
 		default:
 		}
 
-		ok, err := ollama.CheckUrl(u)
+		imageURL, ok, err := fetch.Default.ResolveImage(c.ctx, u)
 		if err != nil {
 			logger.Errorf("Error checking URL %s: %v", u, err)
 			continue
@@ -177,16 +222,17 @@ func (c *Client) processImages(eventCh chan timeline.Event, event timeline.Event
 			continue
 		}
 
-		logger.Infof("Found image: %s", u)
-		desc, err := c.Gemeni.DescribeImageGemeni(c.ctx, u)
+		logger.Infof("Found image: %s", imageURL)
+		desc, err := c.describeImage(imageURL)
 		if err != nil {
 			logger.Errorf("Error describing image %s: %v", u, err)
 			continue
 		}
+		logger.Debugf("image %s described by %s (%s), tokens: %d", u, desc.Model, desc.Backend, desc.Usage.TotalTokens)
 
 		imageEvent := timeline.Event{
 			Type:      timeline.EventImage,
-			Content:   desc,
+			Content:   desc.Text,
 			Author:    event.Author,
 			Streamer:  event.Streamer,
 			Timestamp: event.Timestamp.Add(time.Millisecond), // для правильной последовательности
@@ -204,35 +250,146 @@ func (c *Client) processImages(eventCh chan timeline.Event, event timeline.Event
 }
 
 func messageToEvent(message twitch.PrivateMessage) timeline.Event {
-	return timeline.Event{
-		Type:      timeline.EventChat,
-		Content:   message.Message,
-		Author:    message.User.Name,
-		Streamer:  message.Channel,
-		Timestamp: time.Now(),
+	// время сервера из tmi-sent-ts, у самодельных сообщений его нет
+	timestamp := message.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	event := timeline.Event{
+		Type:        timeline.EventChat,
+		Content:     message.Message,
+		Author:      message.User.Name,
+		Streamer:    message.Channel,
+		Timestamp:   timestamp,
+		ID:          message.ID,
+		UserID:      message.User.ID,
+		DisplayName: message.User.DisplayName,
+		Color:       message.User.Color,
+		Badges:      message.User.Badges,
+	}
+
+	for _, emote := range message.Emotes {
+		for _, pos := range emote.Positions {
+			event.Emotes = append(event.Emotes, timeline.Emote{
+				ID:    emote.ID,
+				Name:  emote.Name,
+				Start: pos.Start,
+				End:   pos.End,
+			})
+		}
+	}
+	if message.Reply != nil {
+		event.ReplyParentID = message.Reply.ParentMsgID
 	}
+
+	return event
 }
 
 func (c *Client) ReactToImages(channels ...string) error {
-	c.TWClient.TWClient.OnPrivateMessage(c.GetHandleSimpleImageResponse())
+	if c.Outbox != nil {
+		go c.Outbox.Run(c.ctx)
+	}
+
+	handleImages := c.GetHandleSimpleImageResponse()
+	c.TWClient.TWClient.OnPrivateMessage(func(message twitch.PrivateMessage) {
+		if c.handleCommand(message) {
+			c.remember(messageToEvent(message))
+			return
+		}
+		handleImages(message)
+	})
+	// сабки и рейды тоже контекст для ответов
+	c.TWClient.TWClient.OnUserNoticeMessage(func(message twitch.UserNoticeMessage) {
+		if event, ok := noticeToEvent(message); ok {
+			c.remember(event)
+		}
+	})
+	// удаленное модерами таймлайн помечает и больше не отдает в промпты
+	c.TWClient.TWClient.OnClearChatMessage(func(message twitch.ClearChatMessage) {
+		c.remember(clearChatToEvent(message))
+	})
+	c.TWClient.TWClient.OnClearMessage(func(message twitch.ClearMessage) {
+		c.remember(clearMessageToEvent(message))
+	})
 	c.TWClient.TWClient.Join(channels...)
+
+	go c.watchPrompts(c.remember, channels...)
 	return nil
 }
 
+// handleCommand отдает сообщение в роутер команд, если он есть.
+// true - сообщение было командой
+func (c *Client) handleCommand(message twitch.PrivateMessage) bool {
+	if c.Commands == nil {
+		return false
+	}
+	return c.Commands.Handle(message)
+}
+
+// channelSettings - персона, язык и включенные фичи для канала
+func (c *Client) channelSettings(channel string) config.ChannelSettings {
+	if c.channels == nil {
+		return config.Default().Channel(channel)
+	}
+	return c.channels(channel)
+}
+
+// watchPrompts перечитывает prompts.yaml пока жив контекст
+// и после каждой удачной перезагрузки отдает в emit по EventGlobal на канал
+func (c *Client) watchPrompts(emit func(timeline.Event), channels ...string) {
+	if c.Prompts == nil {
+		return
+	}
+
+	c.Prompts.Watch(c.ctx, c.promptsReload, func(names []string, err error) {
+		if err != nil {
+			return // уже залогировано, остаемся на старых персонажах
+		}
+		for _, channel := range channels {
+			emit(timeline.Event{
+				Type:      timeline.EventGlobal,
+				Content:   fmt.Sprintf("Prompts reloaded, characters: %s", strings.Join(names, ", ")),
+				Author:    "system",
+				Streamer:  channel,
+				Timestamp: time.Now(),
+			})
+		}
+	})
+}
+
 func (c *Client) GetHandleSimpleImageResponse() func(message twitch.PrivateMessage) {
 	return func(message twitch.PrivateMessage) {
+		settings := c.channelSettings(message.Channel)
 		urls := tw.FindURLs(message.Message)
-		isReplying := strings.Contains(message.Message, "@dawgobot")
+		isReplying := strings.Contains(strings.ToLower(message.Message), "@"+tw.BotName)
+
+		var history []timeline.Event
+		if isReplying && settings.MentionReplies && len(urls) == 0 {
+			history = c.recentEvents(message.Channel, settings.MentionContext)
+		}
+
+		// копим чат, чтобы персонаж видел контекст через .RecentChat
+		c.remember(messageToEvent(message))
+
+		// на кулдауне ответ все равно не уйдет, нейронку не дергаем
+		if !c.canReply(message) {
+			return
+		}
+
 		if len(urls) == 0 {
-			if isReplying {
-				answer := "чел я пока только умею на картинки отвечать"
-				c.TWClient.TWClient.Reply(message.Channel, message.ID, answer)
+			if isReplying && settings.MentionReplies {
+				c.replyToMention(settings, message, history)
 			}
 			return
 		}
 
+		if !settings.ImageReplies {
+			return
+		}
+
 		u := urls[0] // допустим у нас одна картинка
-		ok, err := ollama.CheckUrl(u)
+		imageURL, ok, err := fetch.Default.ResolveImage(c.ctx, u)
 		if err != nil {
 			logger.Errorf("Error checking URL %s: %v", u, err)
 			return
@@ -240,27 +397,71 @@ func (c *Client) GetHandleSimpleImageResponse() func(message twitch.PrivateMessa
 		if !ok {
 			if isReplying {
 				answer := "это не картинка это хуй знает что"
-				c.TWClient.TWClient.Reply(message.Channel, message.ID, answer)
+				c.reply(message, answer)
 			}
 
 			logger.Infof("Not an image: %s", u)
 			return
 		}
 
-		logger.Infof("Found image: %s", u)
-		desc, err := c.Gemeni.DescribeImageGemeni(c.ctx, u)
+		logger.Infof("Found image: %s", imageURL)
+		desc, err := c.describeImage(imageURL)
 		if err != nil {
 			logger.Errorf("Error describing image %s: %v", u, err)
 			return
 		}
 
-		resp, err := c.DSClient.GetResponse("image", desc)
+		c.remember(timeline.Event{
+			Type:      timeline.EventImage,
+			Content:   desc.Text,
+			Author:    message.User.Name,
+			Streamer:  message.Channel,
+			Timestamp: time.Now(),
+		})
+
+		resp, err := c.Chat.Chat(c.ctx, ai.ChatRequest{
+			Character: settings.Persona,
+			Message:   desc.Text,
+			Vars:      c.promptVars(settings, message.User.DisplayName),
+			Models:    settings.Models,
+		})
 		if err != nil {
-			logger.Errorf("err from deepseekk: %w", err)
+			logger.Errorf("err from chat model: %v", err)
 			return
 		}
+		logger.Infof("reply by %s (%s), tokens: %d", resp.Backend, resp.Model, resp.Usage.TotalTokens)
 
 		logger.Infof("replying to @%s", message.User.DisplayName)
-		c.TWClient.TWClient.Reply(message.Channel, message.ID, resp)
+		c.reply(message, resp.Text)
+	}
+}
+
+// canReply - не на кулдауне ли автор сообщения
+func (c *Client) canReply(message twitch.PrivateMessage) bool {
+	return c.Outbox == nil || c.Outbox.Allow(message.Channel, message.User.Name)
+}
+
+// remember кладет событие в таймлайн, если он есть
+func (c *Client) remember(event timeline.Event) {
+	if c.Timeline != nil {
+		c.Timeline.AddEvent(event)
 	}
 }
+
+// reply отвечает в чат и запоминает свой ответ, чтобы бот видел что он уже говорил
+func (c *Client) reply(message twitch.PrivateMessage, text string) {
+	if c.Outbox == nil {
+		for _, part := range tw.FormatMessage(text, tw.DefaultFormatConfig()) {
+			c.TWClient.TWClient.Reply(message.Channel, message.ID, part)
+		}
+	} else if !c.Outbox.Reply(message.Channel, message.ID, message.User.Name, text) {
+		return
+	}
+	c.remember(timeline.Event{
+		Type:      timeline.EventChat,
+		Content:   tw.StripMarkdown(text),
+		Author:    tw.BotName,
+		Streamer:  message.Channel,
+		Timestamp: time.Now(),
+	})
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+
+	"github.com/godovasik/dawgobot/internal/ai"
+	"github.com/godovasik/dawgobot/internal/ai/ollama"
+	"github.com/godovasik/dawgobot/internal/database"
+	"github.com/godovasik/dawgobot/internal/fetch"
+	"github.com/godovasik/dawgobot/logger"
+)
+
+// cacheBackend - Response.Backend у описаний, взятых из базы
+const cacheBackend = "cache"
+
+// maxPHashDistance - насколько могут отличаться dHash, чтобы считать картинки одной и той же.
+// пережатый jpeg обычно 0-3, другая картинка - 20+
+const maxPHashDistance = 5
+
+// describeImage описывает картинку по ссылке, но сначала ищет ее в базе:
+// по ссылке, по sha256 байтов и по перцептивному хешу - один мем часто перезаливают и пережимают.
+// без базы просто качает и зовет vision модель
+func (c *Client) describeImage(u string) (ai.Response, error) {
+	u, err := fetch.NormalizeURL(u)
+	if err != nil {
+		return ai.Response{}, err
+	}
+
+	if c.DB != nil {
+		if img := c.cachedImage(c.DB.GetImageByURL(u)); img != nil {
+			logger.Infof("image %s found in cache", u)
+			return imageResponse(img), nil
+		}
+	}
+
+	// качаем сами даже когда модель умеет ходить по ссылкам: так картинка точно проверена
+	data, err := fetch.Default.Image(c.ctx, u)
+	if err != nil {
+		return ai.Response{}, err
+	}
+	if c.DB == nil {
+		return c.Vision.DescribeImage(c.ctx, imageRequest(u, data))
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	if img := c.cachedImage(c.DB.GetImageByHash(hash)); img != nil {
+		logger.Infof("image %s is a repost of %s", u, img.URL)
+		img.URL = u
+		if err := c.DB.SaveImage(*img); err != nil {
+			logger.Warnf("cant cache image %s: %v", u, err)
+		}
+		return imageResponse(img), nil
+	}
+
+	// у анимаций хешируется склейка: похожие гифки дают похожие склейки
+	request := imageRequest(u, data)
+	phash, err := ollama.DHash(request.Data)
+	if err != nil {
+		logger.Debugf("cant hash image %s: %v", u, err)
+	}
+	img, distance, err := c.DB.GetImageByPHash(phash, maxPHashDistance)
+	if img = c.cachedImage(img, err); img != nil {
+		logger.Infof("image %s looks like %s (distance %d)", u, img.URL, distance)
+		img.URL, img.SHA256, img.PHash = u, hash, phash
+		if err := c.DB.SaveImage(*img); err != nil {
+			logger.Warnf("cant cache image %s: %v", u, err)
+		}
+		return imageResponse(img), nil
+	}
+
+	// картинка уже скачана, второй раз ее качать модели незачем
+	resp, err := c.Vision.DescribeImage(c.ctx, request)
+	if err != nil {
+		return resp, err
+	}
+
+	img = &database.Image{
+		URL:         u,
+		SHA256:      hash,
+		PHash:       phash,
+		Mime:        http.DetectContentType(data),
+		Description: resp.Text,
+		Model:       resp.Model,
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		img.Width, img.Height = cfg.Width, cfg.Height
+	}
+	if err := c.DB.SaveImage(*img); err != nil {
+		logger.Warnf("cant cache image %s: %v", u, err)
+	}
+	return resp, nil
+}
+
+// imageRequest - у гифок модель видит не первый кадр, а склейку из нескольких,
+// а статичные картинки ужимаются до 1024: openrouter шлет байты в data url как есть,
+// а скачать могли и 20 мегабайт
+func imageRequest(u string, data []byte) ai.ImageRequest {
+	request := ai.ImageRequest{URL: u}
+	sheet, frames, err := ollama.AnimationSheet(data, ollama.MaxAnimationFrames)
+	if err != nil {
+		logger.Debugf("cant sample frames of %s: %v", u, err)
+	} else if frames > 0 {
+		request.Data, request.Frames = sheet, frames
+		return request
+	}
+
+	small, err := ollama.ResizeImageBytes(data)
+	if err != nil {
+		// не смогли ужать - пусть модель сама сходит по ссылке
+		logger.Debugf("cant resize %s, sending url instead: %v", u, err)
+		return request
+	}
+	request.Data = small
+	return request
+}
+
+// cachedImage - ошибку кеша только логируем, без него просто сходим в нейронку
+func (c *Client) cachedImage(img *database.Image, err error) *database.Image {
+	if err != nil {
+		logger.Warnf("image cache lookup failed: %v", err)
+		return nil
+	}
+	return img
+}
+
+func imageResponse(img *database.Image) ai.Response {
+	return ai.Response{Text: img.Description, Model: img.Model, Backend: cacheBackend}
+}
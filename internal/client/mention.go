@@ -0,0 +1,58 @@
+package client
+
+import (
+	"fmt"
+
+	twitch "github.com/gempir/go-twitch-irc/v4"
+	"github.com/godovasik/dawgobot/internal/ai"
+	"github.com/godovasik/dawgobot/internal/config"
+	"github.com/godovasik/dawgobot/internal/timeline"
+	"github.com/godovasik/dawgobot/logger"
+)
+
+// recentEvents - последние события канала: сначала из памяти,
+// если там пусто (например бот только запустился) - из базы
+func (c *Client) recentEvents(channel string, n int) []timeline.Event {
+	if c.Timeline != nil {
+		if events := c.Timeline.GetLastStreamerEvents(channel, n); len(events) > 0 {
+			return events
+		}
+	}
+
+	if c.DB != nil {
+		// удаленное модерами обратно в промпт не тащим
+		events, err := c.DB.GetVisibleEventsByCount(channel, n)
+		if err != nil {
+			logger.Errorf("cant get events for %s from db: %v", channel, err)
+			return nil
+		}
+		return events
+	}
+
+	return nil
+}
+
+// replyToMention отвечает на тег с учетом того, что было в чате до него.
+// history собирается до того как сам тег попал в таймлайн, чтобы он не задвоился.
+func (c *Client) replyToMention(settings config.ChannelSettings, message twitch.PrivateMessage, history []timeline.Event) {
+	transcript := timeline.SprintEvents(history)
+	prompt := fmt.Sprintf("Чат:\n%s\nТебя тегнул %s: %s", transcript, message.User.DisplayName, message.Message)
+
+	vars := c.promptVars(settings, message.User.DisplayName)
+	vars.RecentChat = transcript
+
+	resp, err := c.Chat.Chat(c.ctx, ai.ChatRequest{
+		Character: settings.MentionPersona,
+		Message:   prompt,
+		Vars:      vars,
+		Models:    settings.Models,
+	})
+	if err != nil {
+		logger.Errorf("err from chat model: %v", err)
+		return
+	}
+	logger.Infof("mention reply by %s (%s), tokens: %d", resp.Backend, resp.Model, resp.Usage.TotalTokens)
+
+	logger.Infof("replying to @%s", message.User.DisplayName)
+	c.reply(message, resp.Text)
+}
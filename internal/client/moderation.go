@@ -0,0 +1,79 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc/v4"
+	"github.com/godovasik/dawgobot/internal/timeline"
+	"github.com/godovasik/dawgobot/logger"
+)
+
+// GetHandleClearChat - таймауты, баны и очистка чата. сами сообщения помечает база в AddEvents
+func (c *Client) GetHandleClearChat(eventCh chan timeline.Event) func(message twitch.ClearChatMessage) {
+	return func(message twitch.ClearChatMessage) {
+		c.emitModeration(eventCh, clearChatToEvent(message))
+	}
+}
+
+// GetHandleClearMessage - модер удалил одно сообщение
+func (c *Client) GetHandleClearMessage(eventCh chan timeline.Event) func(message twitch.ClearMessage) {
+	return func(message twitch.ClearMessage) {
+		c.emitModeration(eventCh, clearMessageToEvent(message))
+	}
+}
+
+func (c *Client) emitModeration(eventCh chan timeline.Event, event timeline.Event) {
+	select {
+	case eventCh <- event:
+	case <-c.ctx.Done():
+	default:
+		logger.Warn("Event channel full, dropping moderation event")
+	}
+}
+
+func clearChatToEvent(message twitch.ClearChatMessage) timeline.Event {
+	timestamp := message.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	event := timeline.Event{
+		Author:    "system",
+		Streamer:  message.Channel,
+		Timestamp: timestamp,
+	}
+
+	switch {
+	case message.TargetUsername == "":
+		event.Type = timeline.EventChatCleared
+		event.Content = "chat cleared"
+	case message.BanDuration > 0:
+		event.Type = timeline.EventTimeout
+		event.Content = fmt.Sprintf("%s timed out for %ds", message.TargetUsername, message.BanDuration)
+		event.Notice = &timeline.Notice{Target: message.TargetUsername, Duration: message.BanDuration}
+	default:
+		event.Type = timeline.EventBan
+		event.Content = fmt.Sprintf("%s banned", message.TargetUsername)
+		event.Notice = &timeline.Notice{Target: message.TargetUsername}
+	}
+	return event
+}
+
+func clearMessageToEvent(message twitch.ClearMessage) timeline.Event {
+	// у ClearMessage библиотека время не разбирает, берем из тега сами
+	timestamp := time.Now()
+	if ms, err := strconv.ParseInt(message.Tags["tmi-sent-ts"], 10, 64); err == nil {
+		timestamp = time.UnixMilli(ms)
+	}
+
+	return timeline.Event{
+		Type:      timeline.EventMessageDeleted,
+		Content:   fmt.Sprintf("deleted message from %s: %s", message.Login, message.Message),
+		Author:    "system",
+		Streamer:  message.Channel,
+		Timestamp: timestamp,
+		Notice:    &timeline.Notice{Target: message.Login, TargetMsgID: message.TargetMsgID},
+	}
+}
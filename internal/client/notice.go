@@ -0,0 +1,99 @@
+package client
+
+import (
+	"strconv"
+	"time"
+
+	twitch "github.com/gempir/go-twitch-irc/v4"
+	"github.com/godovasik/dawgobot/internal/timeline"
+	"github.com/godovasik/dawgobot/logger"
+)
+
+// GetHandleUserNotice - сабки, рейды и анонсы в таймлайн, как GetHandleMonitor для чата
+func (c *Client) GetHandleUserNotice(eventCh chan timeline.Event) func(message twitch.UserNoticeMessage) {
+	return func(message twitch.UserNoticeMessage) {
+		event, ok := noticeToEvent(message)
+		if !ok {
+			return
+		}
+
+		select {
+		case eventCh <- event:
+		case <-c.ctx.Done():
+			return
+		default:
+			logger.Warn("Event channel full, dropping notice event")
+		}
+	}
+}
+
+// noticeToEvent переводит USERNOTICE в событие.
+// false - тип, который мы не храним (bitsbadgetier, ritual и прочее)
+func noticeToEvent(message twitch.UserNoticeMessage) (timeline.Event, bool) {
+	params := message.MsgParams
+	notice := &timeline.Notice{}
+
+	var eventType timeline.EventType
+	switch message.MsgID {
+	case "sub":
+		eventType = timeline.EventSub
+		notice.Tier = params["msg-param-sub-plan"]
+		notice.Months = paramInt(params, "msg-param-cumulative-months", "msg-param-months")
+	case "resub":
+		eventType = timeline.EventResub
+		notice.Tier = params["msg-param-sub-plan"]
+		notice.Months = paramInt(params, "msg-param-cumulative-months", "msg-param-months")
+	case "subgift", "anonsubgift":
+		eventType = timeline.EventSubGift
+		notice.Tier = params["msg-param-sub-plan"]
+		notice.Months = paramInt(params, "msg-param-months")
+		notice.Recipient = params["msg-param-recipient-display-name"]
+		notice.GiftCount = 1
+	case "submysterygift", "anonsubmysterygift":
+		eventType = timeline.EventSubGift
+		notice.Tier = params["msg-param-sub-plan"]
+		notice.GiftCount = paramInt(params, "msg-param-mass-gift-count")
+	case "raid":
+		eventType = timeline.EventRaid
+		notice.Raider = params["msg-param-displayName"]
+		notice.Viewers = paramInt(params, "msg-param-viewerCount")
+	case "announcement":
+		eventType = timeline.EventAnnouncement
+		notice = nil
+	default:
+		return timeline.Event{}, false
+	}
+
+	// system-msg это текст от твича вида "X subscribed at Tier 1...", сообщение юзера дописываем
+	content := message.SystemMsg
+	if message.Message != "" {
+		if content != "" {
+			content += " "
+		}
+		content += message.Message
+	}
+
+	timestamp := message.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	return timeline.Event{
+		Type:      eventType,
+		Content:   content,
+		Author:    message.User.Name,
+		Streamer:  message.Channel,
+		Timestamp: timestamp,
+		Notice:    notice,
+	}, true
+}
+
+// paramInt - первый из ключей, который есть в msg-param и парсится в число
+func paramInt(params map[string]string, keys ...string) int {
+	for _, key := range keys {
+		if n, err := strconv.Atoi(params[key]); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
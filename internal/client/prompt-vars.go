@@ -0,0 +1,89 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/godovasik/dawgobot/internal/ai/prompts"
+	"github.com/godovasik/dawgobot/internal/config"
+	"github.com/godovasik/dawgobot/internal/timeline"
+	tw "github.com/godovasik/dawgobot/internal/twitch"
+	"github.com/godovasik/dawgobot/logger"
+)
+
+const (
+	// сколько последних событий канала попадает в .RecentChat
+	recentChatSize = 20
+	// helix на каждое сообщение дергать не хочется, игра меняется редко
+	streamInfoTTL = 2 * time.Minute
+)
+
+type cachedStreamInfo struct {
+	info      *tw.StreamerInfo
+	fetchedAt time.Time
+}
+
+type streamInfoCache struct {
+	mu       sync.Mutex
+	infos    map[string]cachedStreamInfo
+	fetching map[string]bool // по каналу уже идет запрос в helix
+}
+
+// promptVars собирает переменные для шаблона персонажа
+func (c *Client) promptVars(settings config.ChannelSettings, author string) *prompts.Vars {
+	channel := settings.Name
+	vars := &prompts.Vars{
+		Streamer: channel,
+		Author:   author,
+		Language: settings.Language,
+	}
+
+	if info := c.streamInfo(channel); info != nil {
+		vars.Game = info.GameName
+		vars.Title = info.Title
+	}
+
+	if c.Timeline != nil {
+		vars.RecentChat = timeline.SprintEvents(c.Timeline.GetLastStreamerEvents(channel, recentChatSize))
+	}
+
+	return vars
+}
+
+// streamInfo возвращает закэшированную инфу о стриме, nil если достать не получилось
+func (c *Client) streamInfo(channel string) *tw.StreamerInfo {
+	if c.TWClient == nil {
+		return nil
+	}
+
+	cache := &c.infoCache
+	cache.mu.Lock()
+	if cache.infos == nil {
+		cache.infos = make(map[string]cachedStreamInfo)
+		cache.fetching = make(map[string]bool)
+	}
+	cached, ok := cache.infos[channel]
+	// пока кто-то другой ходит в helix, отдаем старое, а не ждем его
+	if (ok && time.Since(cached.fetchedAt) < streamInfoTTL) || cache.fetching[channel] {
+		cache.mu.Unlock()
+		return cached.info
+	}
+	cache.fetching[channel] = true
+	cache.mu.Unlock()
+
+	// сетевой запрос без лока, иначе один медленный ответ тормозит промпты во всех каналах
+	info, err := c.TWClient.GetStreamerInfo(channel)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	delete(cache.fetching, channel)
+	if err != nil {
+		logger.Warnf("cant get stream info for %s: %v", channel, err)
+		// отдаем старое если было, и не долбим апи до следующего ttl
+		cache.infos[channel] = cachedStreamInfo{info: cached.info, fetchedAt: time.Now()}
+		return cached.info
+	}
+
+	cache.infos[channel] = cachedStreamInfo{info: info, fetchedAt: time.Now()}
+	return info
+}
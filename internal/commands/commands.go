@@ -0,0 +1,256 @@
+package commands
+
+// команды в чате вида "!имя аргументы".
+// права считаются по бейджам из IRC: broadcaster > moderator > vip > subscriber > все остальные.
+//
+// как использовать:
+//
+// router := commands.NewRouter("!", func(channel, parentMsgID, user, text string) {
+// 	outbox.Command(channel, parentMsgID, user, text)
+// })
+// router.Register(commands.Command{
+// 	Name:    "ping",
+// 	Aliases: []string{"пинг"},
+// 	Handler: func(ctx *commands.Context) error {
+// 		ctx.Reply("pong")
+// 		return nil
+// 	},
+// })
+// handled := router.Handle(message) // true если это была команда
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	twitch "github.com/gempir/go-twitch-irc/v4"
+	"github.com/godovasik/dawgobot/logger"
+)
+
+// ErrUsage - хендлер возвращает ее, если аргументы кривые, в ответ уйдет Usage
+var ErrUsage = errors.New("wrong usage")
+
+type Level int
+
+const (
+	Everyone Level = iota
+	Subscriber
+	VIP
+	Moderator
+	Broadcaster
+)
+
+func (l Level) String() string {
+	switch l {
+	case Subscriber:
+		return "subscriber"
+	case VIP:
+		return "vip"
+	case Moderator:
+		return "moderator"
+	case Broadcaster:
+		return "broadcaster"
+	default:
+		return "everyone"
+	}
+}
+
+// LevelFromBadges - максимальный уровень, который дают бейджи пользователя
+func LevelFromBadges(badges map[string]int) Level {
+	switch {
+	case badges["broadcaster"] > 0:
+		return Broadcaster
+	case badges["moderator"] > 0:
+		return Moderator
+	case badges["vip"] > 0:
+		return VIP
+	case badges["subscriber"] > 0, badges["founder"] > 0:
+		return Subscriber
+	default:
+		return Everyone
+	}
+}
+
+type Context struct {
+	Message twitch.PrivateMessage
+	Name    string   // как вызвали команду: имя или алиас
+	Args    []string // аргументы без самой команды
+	Level   Level    // уровень того, кто вызвал
+
+	reply func(text string)
+}
+
+// Reply отвечает на сообщение с командой
+func (c *Context) Reply(text string) {
+	c.reply(text)
+}
+
+type Command struct {
+	Name        string
+	Aliases     []string
+	Description string
+	Usage       string // например "!help [команда]"
+	Level       Level  // минимальный уровень для вызова
+	Handler     func(ctx *Context) error
+}
+
+type Router struct {
+	prefix   string
+	reply    func(channel, parentMsgID, user, text string)
+	commands map[string]*Command // по имени и по алиасам
+	list     []*Command          // в порядке регистрации, для !help
+}
+
+// NewRouter создает роутер со встроенной командой help.
+// reply - чем отвечать в чат, обычно twitch.Outbox.Command, user - кто вызвал команду
+func NewRouter(prefix string, reply func(channel, parentMsgID, user, text string)) *Router {
+	r := &Router{
+		prefix:   prefix,
+		reply:    reply,
+		commands: make(map[string]*Command),
+	}
+	r.MustRegister(Command{
+		Name:        "help",
+		Aliases:     []string{"commands", "помощь"},
+		Description: "список команд или справка по одной",
+		Usage:       prefix + "help [команда]",
+		Handler:     r.help,
+	})
+	return r
+}
+
+// Register добавляет команду, имя и алиасы не должны пересекаться с уже существующими
+func (r *Router) Register(cmd Command) error {
+	if cmd.Name == "" || cmd.Handler == nil {
+		return fmt.Errorf("command must have name and handler")
+	}
+
+	names := append([]string{cmd.Name}, cmd.Aliases...)
+	for _, name := range names {
+		if _, ok := r.commands[strings.ToLower(name)]; ok {
+			return fmt.Errorf("command %s already registered", name)
+		}
+	}
+
+	c := &cmd
+	for _, name := range names {
+		r.commands[strings.ToLower(name)] = c
+	}
+	r.list = append(r.list, c)
+	return nil
+}
+
+func (r *Router) MustRegister(cmd Command) {
+	if err := r.Register(cmd); err != nil {
+		panic(err)
+	}
+}
+
+// Handle выполняет команду из сообщения. Возвращает true, если сообщение было командой,
+// даже если прав не хватило - такие сообщения дальше обрабатывать не надо.
+func (r *Router) Handle(message twitch.PrivateMessage) bool {
+	text := strings.TrimSpace(message.Message)
+	if !strings.HasPrefix(text, r.prefix) {
+		return false
+	}
+
+	args := ParseArgs(strings.TrimPrefix(text, r.prefix))
+	if len(args) == 0 {
+		return false
+	}
+
+	name := strings.ToLower(args[0])
+	cmd, ok := r.commands[name]
+	if !ok {
+		return false
+	}
+
+	level := LevelFromBadges(message.User.Badges)
+	if level < cmd.Level {
+		logger.Debugf("%s tried %s%s without rights (%s < %s)", message.User.Name, r.prefix, name, level, cmd.Level)
+		return true
+	}
+
+	ctx := &Context{
+		Message: message,
+		Name:    name,
+		Args:    args[1:],
+		Level:   level,
+		reply: func(text string) {
+			r.reply(message.Channel, message.ID, message.User.Name, text)
+		},
+	}
+
+	logger.Infof("command %s%s from %s in %s", r.prefix, name, message.User.Name, message.Channel)
+	err := cmd.Handler(ctx)
+	switch {
+	case errors.Is(err, ErrUsage):
+		ctx.Reply("использование: " + cmd.Usage)
+	case err != nil:
+		logger.Errorf("command %s failed: %v", cmd.Name, err)
+	}
+	return true
+}
+
+func (r *Router) help(ctx *Context) error {
+	if len(ctx.Args) > 0 {
+		cmd, ok := r.commands[strings.ToLower(strings.TrimPrefix(ctx.Args[0], r.prefix))]
+		if !ok || ctx.Level < cmd.Level {
+			ctx.Reply("нет такой команды")
+			return nil
+		}
+		text := r.prefix + cmd.Name
+		if cmd.Description != "" {
+			text += " - " + cmd.Description
+		}
+		if cmd.Usage != "" {
+			text += ". использование: " + cmd.Usage
+		}
+		if len(cmd.Aliases) > 0 {
+			text += ". алиасы: " + strings.Join(cmd.Aliases, ", ")
+		}
+		ctx.Reply(text)
+		return nil
+	}
+
+	var names []string
+	for _, cmd := range r.list {
+		if ctx.Level >= cmd.Level {
+			names = append(names, r.prefix+cmd.Name)
+		}
+	}
+	sort.Strings(names)
+	ctx.Reply("команды: " + strings.Join(names, " "))
+	return nil
+}
+
+// ParseArgs делит строку по пробелам, "так можно" передать аргумент с пробелами
+func ParseArgs(s string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes := false
+	hasArg := false
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		default:
+			current.WriteRune(r)
+			hasArg = true
+		}
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+
+	return args
+}
@@ -0,0 +1,208 @@
+package config
+
+// конфиг бота. читается из yaml (по умолчанию config.yaml, путь можно задать через DAWGOBOT_CONFIG),
+// потом поверх накладываются переменные окружения. если файла нет - берутся дефолты,
+// они совпадают с тем что раньше было захардкожено.
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/godovasik/dawgobot/internal/ai/deepseek"
+	"github.com/godovasik/dawgobot/internal/ai/ollama"
+	"github.com/godovasik/dawgobot/internal/ai/openrouter"
+	"github.com/godovasik/dawgobot/internal/ai/prompts"
+	"github.com/godovasik/dawgobot/internal/database"
+	"github.com/godovasik/dawgobot/internal/fetch"
+	"github.com/godovasik/dawgobot/internal/twitch"
+	"github.com/godovasik/dawgobot/logger"
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultPath = "config.yaml"
+
+type Config struct {
+	DeepSeek   deepseek.Config          `yaml:"deepseek"`
+	OpenRouter openrouter.Config        `yaml:"openrouter"`
+	Ollama     ollama.Config            `yaml:"ollama"`
+	Fallback   Fallback                 `yaml:"fallback"`
+	Prompts    prompts.Config           `yaml:"prompts"`
+	Database   database.Config          `yaml:"database"`
+	Privacy    database.AnonymizeConfig `yaml:"privacy"` // для выгрузок из базы
+	Retention  database.RetentionConfig `yaml:"retention"`
+
+	Outbox twitch.OutboxConfig `yaml:"outbox"`
+	Fetch  fetch.Config        `yaml:"fetch"` // как качать картинки по ссылкам из чата
+
+	ChannelDefaults Channel            `yaml:"channel_defaults"`
+	Channels        map[string]Channel `yaml:"channels"`
+}
+
+// Channel - настройки бота в конкретном чате, пустые поля берутся из channel_defaults
+type Channel struct {
+	Persona        string `yaml:"persona"`  // персонаж из prompts.yaml
+	Language       string `yaml:"language"` // доступен в промпте как .Language
+	ImageReplies   *bool  `yaml:"image_replies"`
+	MentionReplies *bool  `yaml:"mention_replies"`
+	MentionPersona string `yaml:"mention_persona"` // персонаж для ответов на теги
+	MentionContext int    `yaml:"mention_context"` // сколько последних событий чата видит бот
+
+	// модель по имени бэкенда (deepseek, openrouter, ollama): id у каждого провайдера свои.
+	// бэкенды, которых тут нет, берут модель из своих настроек
+	Models map[string]string `yaml:"models"`
+}
+
+// ChannelSettings - Channel после подстановки дефолтов
+type ChannelSettings struct {
+	Name           string
+	Persona        string
+	Language       string
+	Models         map[string]string
+	ImageReplies   bool
+	MentionReplies bool
+	MentionPersona string
+	MentionContext int
+}
+
+// Fallback - в каком порядке пробовать текстовые модели и когда их выключать
+type Fallback struct {
+	Order     []string      `yaml:"order"`
+	Threshold int           `yaml:"threshold"`
+	Cooldown  time.Duration `yaml:"cooldown"`
+}
+
+func Default() *Config {
+	return &Config{
+		DeepSeek:   deepseek.DefaultConfig(),
+		OpenRouter: openrouter.DefaultConfig(),
+		Ollama:     ollama.DefaultConfig(),
+		Fallback: Fallback{
+			Order:     []string{"deepseek", "openrouter", "ollama"},
+			Threshold: 3,
+			Cooldown:  2 * time.Minute,
+		},
+		Prompts:   prompts.DefaultConfig(),
+		Database:  database.DefaultConfig(),
+		Privacy:   database.DefaultAnonymizeConfig(),
+		Retention: database.DefaultRetentionConfig(),
+		Outbox:    twitch.DefaultOutboxConfig(),
+		Fetch:     fetch.DefaultConfig(),
+		ChannelDefaults: Channel{
+			Persona:        "image",
+			Language:       "ru",
+			ImageReplies:   ptr(true),
+			MentionReplies: ptr(true),
+			MentionPersona: "mention",
+			MentionContext: 30,
+		},
+	}
+}
+
+// Channel возвращает настройки канала, для неизвестных каналов - дефолтные
+func (c *Config) Channel(name string) ChannelSettings {
+	ch := c.Channels[strings.ToLower(name)]
+	def := c.ChannelDefaults
+
+	return ChannelSettings{
+		Name:           name,
+		Persona:        cmp.Or(ch.Persona, def.Persona),
+		Language:       cmp.Or(ch.Language, def.Language),
+		Models:         mergeModels(def.Models, ch.Models),
+		ImageReplies:   firstBool(ch.ImageReplies, def.ImageReplies),
+		MentionReplies: firstBool(ch.MentionReplies, def.MentionReplies),
+		MentionPersona: cmp.Or(ch.MentionPersona, def.MentionPersona),
+		MentionContext: cmp.Or(ch.MentionContext, def.MentionContext),
+	}
+}
+
+// mergeModels - модели канала поверх дефолтных, по каждому бэкенду отдельно
+func mergeModels(def, ch map[string]string) map[string]string {
+	if len(def) == 0 && len(ch) == 0 {
+		return nil
+	}
+	models := make(map[string]string, len(def)+len(ch))
+	for name, model := range def {
+		models[strings.ToLower(name)] = model
+	}
+	for name, model := range ch {
+		if model != "" {
+			models[strings.ToLower(name)] = model
+		}
+	}
+	return models
+}
+
+func firstBool(values ...*bool) bool {
+	for _, v := range values {
+		if v != nil {
+			return *v
+		}
+	}
+	return false
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// Load читает конфиг по пути из DAWGOBOT_CONFIG или из config.yaml
+func Load() (*Config, error) {
+	path := os.Getenv("DAWGOBOT_CONFIG")
+	if path == "" {
+		path = DefaultPath
+	}
+	return LoadFile(path)
+}
+
+func LoadFile(path string) (*Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		logger.Infof("no config at %s, using defaults", path)
+	case err != nil:
+		return nil, fmt.Errorf("cant read config: %w", err)
+	default:
+		// поля которых нет в файле остаются дефолтными
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("cant unmarshal config: %w", err)
+		}
+		logger.Infof("config loaded from %s", path)
+	}
+
+	cfg.applyEnv()
+	if err := cfg.Outbox.Format.Validate(); err != nil {
+		return nil, fmt.Errorf("bad outbox.format: %w", err)
+	}
+	return cfg, nil
+}
+
+// applyEnv - переменные окружения главнее файла
+func (c *Config) applyEnv() {
+	setFromEnv(&c.DeepSeek.Token, "DEEPSEEK_TOKEN")
+	setFromEnv(&c.DeepSeek.BaseURL, "DEEPSEEK_BASE_URL")
+	setFromEnv(&c.DeepSeek.Model, "DEEPSEEK_MODEL")
+
+	setFromEnv(&c.OpenRouter.Token, "OPENROUTER_TOKEN")
+	setFromEnv(&c.OpenRouter.BaseURL, "OPENROUTER_BASE_URL")
+	setFromEnv(&c.OpenRouter.ChatModel, "OPENROUTER_CHAT_MODEL")
+	setFromEnv(&c.OpenRouter.VisionModel, "OPENROUTER_VISION_MODEL")
+
+	setFromEnv(&c.Ollama.BaseURL, "OLLAMA_BASE_URL")
+	setFromEnv(&c.Ollama.ChatModel, "OLLAMA_CHAT_MODEL")
+	setFromEnv(&c.Ollama.VisionModel, "OLLAMA_VISION_MODEL")
+
+	setFromEnv(&c.Prompts.Path, "DAWGOBOT_PROMPTS")
+	setFromEnv(&c.Database.Path, "DAWGOBOT_DB")
+}
+
+func setFromEnv(field *string, name string) {
+	if v := os.Getenv(name); v != "" {
+		*field = v
+	}
+}
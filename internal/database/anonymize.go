@@ -0,0 +1,133 @@
+package database
+
+// чистка персональных данных перед выгрузкой: ники чаттеров меняются на user1, user2...
+// (одинаково в пределах одной выгрузки), ссылки, почты и телефоны вырезаются,
+// а сообщения тех, кто попросил их не собирать, выкидываются целиком, как и упоминания их ников.
+// Anonymizer делается заново на каждую выгрузку, nil - ничего не трогать.
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/godovasik/dawgobot/internal/timeline"
+)
+
+type AnonymizeConfig struct {
+	Pseudonymize bool     `yaml:"pseudonymize"` // заменять ники на user1, user2...
+	Redact       bool     `yaml:"redact"`       // вырезать ссылки, почты и телефоны
+	OptOut       []string `yaml:"opt_out"`      // чьи сообщения не выгружать вообще
+	Keep         []string `yaml:"keep"`         // чьи ники не трогать, например бота. стример не трогается всегда
+}
+
+func DefaultAnonymizeConfig() AnonymizeConfig {
+	return AnonymizeConfig{
+		Pseudonymize: true,
+		Redact:       true,
+	}
+}
+
+var (
+	emailRegex   = regexp.MustCompile(`(?i)\b[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,}\b`)
+	urlRegex     = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+	domainRegex  = regexp.MustCompile(`(?i)\b[a-z0-9](?:[a-z0-9\-]*[a-z0-9])?(?:\.[a-z0-9\-]+)*\.(?:com|ru|net|org|io|gg|tv|me|co|xyz|su|рф|be|ly|to|cc|app|dev)\b(?:/\S*)?`)
+	phoneRegex   = regexp.MustCompile(`(?:(?:\+\d{1,3}|\b8)[\s\-]?)?(?:\(\d{3}\)|\d{3})[\s\-]?\d{3}[\s\-]?\d{2}[\s\-]?\d{2}\b|\+\d{10,14}\b`)
+	mentionRegex = regexp.MustCompile(`@([a-zA-Z0-9_]{3,25})`)
+)
+
+type Anonymizer struct {
+	cfg        AnonymizeConfig
+	optOut     map[string]bool
+	keep       map[string]bool
+	pseudonyms map[string]string
+}
+
+func NewAnonymizer(cfg AnonymizeConfig) *Anonymizer {
+	a := &Anonymizer{
+		cfg:        cfg,
+		optOut:     make(map[string]bool, len(cfg.OptOut)),
+		keep:       make(map[string]bool, len(cfg.Keep)),
+		pseudonyms: make(map[string]string),
+	}
+	for _, name := range cfg.OptOut {
+		a.optOut[strings.ToLower(name)] = true
+	}
+	for _, name := range cfg.Keep {
+		a.keep[strings.ToLower(name)] = true
+	}
+	return a
+}
+
+// Drop - событие от того, кто отказался от сбора, его надо выкинуть
+func (a *Anonymizer) Drop(event timeline.Event) bool {
+	if a == nil {
+		return false
+	}
+	return a.optOut[strings.ToLower(event.Author)]
+}
+
+// Event возвращает копию события без персональных данных
+func (a *Anonymizer) Event(event timeline.Event) timeline.Event {
+	if a == nil {
+		return event
+	}
+
+	event.Author = a.name(event.Author, event.Streamer)
+	event.Content = a.Text(event.Content, event.Streamer)
+
+	if a.cfg.Pseudonymize {
+		event.UserID = ""
+		event.DisplayName = ""
+		event.Color = ""
+		if event.Notice != nil {
+			notice := *event.Notice
+			notice.Raider = a.name(notice.Raider, event.Streamer)
+			notice.Recipient = a.name(notice.Recipient, event.Streamer)
+			notice.Target = a.name(notice.Target, event.Streamer)
+			event.Notice = &notice
+		}
+	}
+	return event
+}
+
+// Text чистит текст сообщения: вырезает контакты и меняет @ники.
+// Упоминания тех, кто в opt_out, вырезаются всегда, даже без псевдонимов и с -raw
+func (a *Anonymizer) Text(text, streamer string) string {
+	if a == nil {
+		return text
+	}
+
+	if a.cfg.Redact {
+		// почту раньше ссылок, иначе домен из нее съест domainRegex
+		text = emailRegex.ReplaceAllString(text, "<email>")
+		text = urlRegex.ReplaceAllString(text, "<url>")
+		text = domainRegex.ReplaceAllString(text, "<url>")
+		text = phoneRegex.ReplaceAllString(text, "<phone>")
+	}
+	text = mentionRegex.ReplaceAllStringFunc(text, func(mention string) string {
+		name := strings.TrimPrefix(mention, "@")
+		if a.optOut[strings.ToLower(name)] {
+			return "@<redacted>"
+		}
+		return "@" + a.name(name, streamer)
+	})
+	return text
+}
+
+// name - псевдоним для ника, один и тот же в пределах Anonymizer
+func (a *Anonymizer) name(name, streamer string) string {
+	if !a.cfg.Pseudonymize || name == "" {
+		return name
+	}
+	key := strings.ToLower(name)
+	if key == strings.ToLower(streamer) || key == "system" || a.keep[key] {
+		return name
+	}
+
+	pseudonym, ok := a.pseudonyms[key]
+	if !ok {
+		pseudonym = fmt.Sprintf("user%d", len(a.pseudonyms)+1)
+		a.pseudonyms[key] = pseudonym
+	}
+	return pseudonym
+}
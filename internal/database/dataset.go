@@ -0,0 +1,224 @@
+package database
+
+// выгрузка timeline в датасет для лоры: jsonl в чат-формате, по строке на пример
+//
+//	{"messages":[{"role":"system",...},{"role":"user","content":"<последние N событий>"},{"role":"assistant","content":"ник: сообщение"}]}
+//
+// user - окно чата, картинок и речи перед сообщением, assistant - само сообщение из чата.
+// окно обрывается на смене стримера и на паузах дольше MaxGap, чтобы не склеивать разные стримы.
+// train/val делится по стримеру и дню: одни и те же сообщения не попадут в окна обеих частей,
+// и при повторной выгрузке разбиение то же самое.
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/godovasik/dawgobot/internal/timeline"
+)
+
+const DefaultDatasetSystem = "Ты зритель в твич чате у {streamer}. Напиши следующее сообщение в чат."
+
+type DatasetOptions struct {
+	Streamers []string // пусто - все
+	From, To  time.Time
+	// какие события попадают в окно контекста, пусто - чат, картинки и речь.
+	// ответом всегда бывает только EventChat
+	Types []timeline.EventType
+
+	Window      int           // сколько событий в окне, по умолчанию 20
+	MinContext  int           // окна короче пропускаются, по умолчанию 3
+	Stride      int           // брать каждое Stride-е сообщение как ответ, по умолчанию 1
+	MaxGap      time.Duration // пауза, после которой окно начинается заново, по умолчанию 10 минут
+	ValFraction float64       // доля дней в validation, 0 - все в train
+	System      string        // системный промпт, {streamer} заменяется на ник. пусто - DefaultDatasetSystem
+
+	ExcludeAuthors []string // чьи сообщения не брать ответами, например самого бота
+
+	Anonymizer *Anonymizer // nil - ники и ссылки как есть
+}
+
+type DatasetStats struct {
+	Events int // сколько событий прочитано
+	Train  int
+	Val    int
+}
+
+type datasetMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type datasetSample struct {
+	Messages []datasetMessage `json:"messages"`
+}
+
+func (o *DatasetOptions) setDefaults() {
+	if len(o.Types) == 0 {
+		o.Types = []timeline.EventType{timeline.EventChat, timeline.EventImage, timeline.EventSpeech}
+	}
+	if o.Window <= 0 {
+		o.Window = 20
+	}
+	if o.MinContext <= 0 {
+		o.MinContext = 3
+	}
+	if o.Stride <= 0 {
+		o.Stride = 1
+	}
+	if o.MaxGap <= 0 {
+		o.MaxGap = 10 * time.Minute
+	}
+	if o.System == "" {
+		o.System = DefaultDatasetSystem
+	}
+}
+
+// ExportDataset пишет примеры в train и val. удаленное модерами в датасет не попадает вообще
+func (db *DB) ExportDataset(opts DatasetOptions, train, val io.Writer) (DatasetStats, error) {
+	opts.setDefaults()
+	var stats DatasetStats
+
+	where := []string{"moderation IS NULL"}
+	var args []any
+
+	types := make([]string, 0, len(opts.Types))
+	hasChat := false
+	for _, t := range opts.Types {
+		types = append(types, "?")
+		args = append(args, int(t))
+		hasChat = hasChat || t == timeline.EventChat
+	}
+	if !hasChat {
+		// без чата не из чего делать ответы
+		types = append(types, "?")
+		args = append(args, int(timeline.EventChat))
+	}
+	where = append(where, "event_type IN ("+strings.Join(types, ", ")+")")
+
+	if len(opts.Streamers) > 0 {
+		where = append(where, "streamer_name IN (?"+strings.Repeat(", ?", len(opts.Streamers)-1)+")")
+		for _, s := range opts.Streamers {
+			args = append(args, strings.ToLower(s))
+		}
+	}
+	if !opts.From.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, opts.From)
+	}
+	if !opts.To.IsZero() {
+		where = append(where, "timestamp <= ?")
+		args = append(args, opts.To)
+	}
+
+	rows, err := db.conn.Query(`
+		SELECT `+eventColumns+`
+		FROM timeline
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY streamer_name, timestamp, id`, args...)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	excluded := make(map[string]bool, len(opts.ExcludeAuthors))
+	for _, a := range opts.ExcludeAuthors {
+		excluded[strings.ToLower(a)] = true
+	}
+
+	trainEnc := json.NewEncoder(train)
+	valEnc := json.NewEncoder(val)
+
+	var window []timeline.Event
+	targets := 0
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return stats, err
+		}
+		stats.Events++
+
+		// отказавшихся выкидываем и из ответов, и из контекста
+		if opts.Anonymizer.Drop(event) {
+			continue
+		}
+		excludedAuthor := excluded[strings.ToLower(event.Author)]
+		event = opts.Anonymizer.Event(event)
+
+		if len(window) > 0 {
+			last := window[len(window)-1]
+			if last.Streamer != event.Streamer || event.Timestamp.Sub(last.Timestamp) > opts.MaxGap {
+				window = window[:0]
+			}
+		}
+
+		if event.Type == timeline.EventChat && len(window) >= opts.MinContext && !excludedAuthor {
+			targets++
+			if (targets-1)%opts.Stride == 0 {
+				sample := datasetSample{Messages: []datasetMessage{
+					{Role: "system", Content: strings.ReplaceAll(opts.System, "{streamer}", event.Streamer)},
+					{Role: "user", Content: strings.TrimSpace(timeline.SprintEvents(window))},
+					{Role: "assistant", Content: fmt.Sprintf("%s: %s", event.Author, event.Content)},
+				}}
+
+				enc := trainEnc
+				if isValidation(event, opts.ValFraction) {
+					enc = valEnc
+					stats.Val++
+				} else {
+					stats.Train++
+				}
+				if err := enc.Encode(sample); err != nil {
+					return stats, err
+				}
+			}
+		}
+
+		// чат попадает в выборку всегда, но в окно - только если его просили
+		if containsType(opts.Types, event.Type) {
+			window = append(window, event)
+			if len(window) > opts.Window {
+				window = window[1:]
+			}
+		}
+	}
+
+	return stats, rows.Err()
+}
+
+// isValidation - детерминированно по стримеру и дню по UTC
+func isValidation(event timeline.Event, fraction float64) bool {
+	if fraction <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(event.Streamer + "/" + event.Timestamp.UTC().Format(time.DateOnly)))
+	return float64(h.Sum32()%10000) < fraction*10000
+}
+
+func containsType(types []timeline.EventType, t timeline.EventType) bool {
+	for _, x := range types {
+		if x == t {
+			return true
+		}
+	}
+	return false
+}
+
+// EventTypeName - имя типа как в логах и флагах: chat, image, sub...
+func (db *DB) EventTypeName(t timeline.EventType) string {
+	return strings.ToLower(db.eventTypeToString(t))
+}
+
+// ParseEventType - обратное к eventTypeToString, без учета регистра
+func (db *DB) ParseEventType(name string) (timeline.EventType, error) {
+	for t := timeline.EventGlobal; t <= timeline.EventChatCleared; t++ {
+		if strings.EqualFold(db.eventTypeToString(t), name) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown event type %q", name)
+}
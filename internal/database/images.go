@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"math/bits"
+	"time"
+)
+
+// Image - описание картинки, которое уже получали от нейронки
+type Image struct {
+	URL         string
+	SHA256      string // hex от байтов картинки
+	PHash       uint64 // ollama.DHash, 0 - не посчитан
+	Mime        string
+	Width       int
+	Height      int
+	Description string
+	Model       string
+	CreatedAt   time.Time
+}
+
+const imageColumns = `url, sha256, mime, width, height, description, model, created_at, phash`
+
+// GetImageByURL - описание по ссылке, nil если такой ссылки еще не было
+func (db *DB) GetImageByURL(url string) (*Image, error) {
+	return db.getImage(`SELECT `+imageColumns+` FROM images WHERE url = ?`, url)
+}
+
+// GetImageByHash - описание той же картинки, залитой по другой ссылке, nil если не было
+func (db *DB) GetImageByHash(sha256 string) (*Image, error) {
+	return db.getImage(`SELECT `+imageColumns+` FROM images WHERE sha256 = ? ORDER BY id DESC LIMIT 1`, sha256)
+}
+
+func (db *DB) getImage(query string, args ...any) (*Image, error) {
+	var img Image
+	var mime, model sql.NullString
+	var width, height, phash sql.NullInt64
+
+	err := db.conn.QueryRow(query, args...).Scan(
+		&img.URL, &img.SHA256, &mime, &width, &height, &img.Description, &model, &img.CreatedAt, &phash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	img.Mime = mime.String
+	img.Model = model.String
+	img.Width = int(width.Int64)
+	img.Height = int(height.Int64)
+	img.PHash = uint64(phash.Int64)
+	return &img, nil
+}
+
+// SaveImage запоминает описание. если url уже есть - перезаписывает
+func (db *DB) SaveImage(img Image) error {
+	if img.CreatedAt.IsZero() {
+		img.CreatedAt = time.Now()
+	}
+
+	_, err := db.conn.Exec(`
+		INSERT INTO images (`+imageColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(url) DO UPDATE SET
+			sha256 = excluded.sha256, mime = excluded.mime,
+			width = excluded.width, height = excluded.height,
+			description = excluded.description, model = excluded.model,
+			created_at = excluded.created_at, phash = excluded.phash`,
+		img.URL, img.SHA256, nullString(img.Mime), img.Width, img.Height,
+		img.Description, nullString(img.Model), img.CreatedAt, nullPHash(img.PHash))
+	return err
+}
+
+// GetImageByPHash - самая похожая картинка, у которой хеш отличается не больше чем на maxDistance бит.
+// хеши сравниваются в Go перебором, sqlite так не умеет; на десятках тысяч картинок это все еще быстро.
+// nil если похожих нет, второе значение - расстояние до найденной
+func (db *DB) GetImageByPHash(phash uint64, maxDistance int) (*Image, int, error) {
+	if phash == 0 {
+		return nil, 0, nil
+	}
+
+	rows, err := db.conn.Query(`SELECT id, phash FROM images WHERE phash IS NOT NULL`)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	bestID, bestDistance := int64(-1), maxDistance+1
+	for rows.Next() {
+		var id, other int64
+		if err := rows.Scan(&id, &other); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		if d := bits.OnesCount64(phash ^ uint64(other)); d < bestDistance {
+			bestID, bestDistance = id, d
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if bestID < 0 {
+		return nil, 0, nil
+	}
+
+	img, err := db.getImage(`SELECT `+imageColumns+` FROM images WHERE id = ?`, bestID)
+	return img, bestDistance, err
+}
+
+// nullPHash - 0 у однотонных картинок и у тех, что не раскодировались, такие не сравниваем
+func nullPHash(phash uint64) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(phash), Valid: phash != 0}
+}
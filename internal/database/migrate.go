@@ -0,0 +1,212 @@
+package database
+
+// миграции схемы. каждая - файл migrations/NNNN_имя.sql, применяются по порядку,
+// каждая в своей транзакции, номер последней лежит в schema_version.
+// новые колонки добавлять только новым файлом, старые файлы не трогать.
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godovasik/dawgobot/logger"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// legacyVersion - до какой версии доходили базы без schema_version,
+// их колонки добирались через ensureColumns
+const legacyVersion = 4
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations - все миграции по возрастанию версии
+func Migrations() ([]Migration, error) {
+	files, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, file := range files {
+		base := strings.TrimSuffix(path.Base(file), ".sql")
+		num, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("bad migration name %s, want NNNN_name.sql", file)
+		}
+		version, err := strconv.Atoi(num)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version in %s: %w", file, err)
+		}
+
+		data, err := migrationsFS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// SchemaVersion - текущая версия базы и миграции, которые еще не применены.
+// у базы без schema_version версия 0, даже если timeline в ней уже есть
+func (db *DB) SchemaVersion() (int, []Migration, error) {
+	current, err := db.currentVersion()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	return current, pending, nil
+}
+
+// Migrate применяет все недостающие миграции и возвращает примененные.
+// старая база без schema_version сначала отмечается как legacyVersion, это тоже попадет в список
+func (db *DB) Migrate() ([]Migration, error) {
+	baselined, err := db.initVersionTable()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	if baselined {
+		applied = append(applied, Migration{Version: legacyVersion, Name: "legacy"})
+	}
+
+	_, pending, err := db.SchemaVersion()
+	if err != nil {
+		return applied, err
+	}
+
+	for _, m := range pending {
+		if err := db.apply(m); err != nil {
+			return applied, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		logger.Infof("applied migration %04d_%s", m.Version, m.Name)
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+func (db *DB) apply(m Migration) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	if err := setVersion(tx, m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// initVersionTable создает schema_version. если таблицы не было, а timeline уже есть -
+// это база из времен до миграций: добиваем колонки и отмечаем ее как legacyVersion.
+// true - база была старой и ее отметили
+func (db *DB) initVersionTable() (bool, error) {
+	exists, err := db.tableExists("schema_version")
+	if err != nil || exists {
+		return false, err
+	}
+	legacy, err := db.tableExists("timeline")
+	if err != nil {
+		return false, err
+	}
+
+	_, err = db.conn.Exec(`
+	CREATE TABLE schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return false, err
+	}
+	if !legacy {
+		return false, nil
+	}
+
+	logger.Infof("found database without schema_version, marking it as version %d", legacyVersion)
+	err = db.ensureColumns("timeline", map[string]string{
+		"meta":            "TEXT",
+		"msg_id":          "TEXT",
+		"moderation":      "TEXT",
+		"user_id":         "TEXT",
+		"display_name":    "TEXT",
+		"color":           "TEXT",
+		"badges":          "TEXT",
+		"emotes":          "TEXT",
+		"reply_parent_id": "TEXT",
+	})
+	if err != nil {
+		return false, err
+	}
+	if _, err := db.conn.Exec(`CREATE INDEX IF NOT EXISTS idx_timeline_msg_id ON timeline(msg_id)`); err != nil {
+		return false, err
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if err := setVersion(tx, legacyVersion, "legacy"); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (db *DB) currentVersion() (int, error) {
+	exists, err := db.tableExists("schema_version")
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	err = db.conn.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&version)
+	return int(version.Int64), err
+}
+
+func setVersion(tx *sql.Tx, version int, name string) error {
+	_, err := tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+		version, name, time.Now())
+	return err
+}
+
+func (db *DB) tableExists(name string) (bool, error) {
+	var n int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n)
+	return n > 0, err
+}
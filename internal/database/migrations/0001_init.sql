@@ -0,0 +1,17 @@
+-- таблица событий, как она была до миграций
+CREATE TABLE IF NOT EXISTS timeline (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	streamer_name TEXT NOT NULL,
+	author TEXT,
+	event_type INTEGER NOT NULL,
+	content TEXT NOT NULL,
+	timestamp DATETIME NOT NULL
+);
+
+-- Составной индекс для быстрых запросов по стримеру и времени
+CREATE INDEX IF NOT EXISTS idx_timeline_streamer_time
+ON timeline(streamer_name, timestamp);
+
+-- Дополнительный индекс по типу событий для фильтрации
+CREATE INDEX IF NOT EXISTS idx_timeline_event_type
+ON timeline(event_type);
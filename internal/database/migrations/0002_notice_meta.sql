@@ -0,0 +1,2 @@
+-- json с timeline.Notice: месяцы сабки, рейдер и т.д.
+ALTER TABLE timeline ADD COLUMN meta TEXT;
@@ -0,0 +1,5 @@
+-- id сообщения в твиче и пометка модерации (timeline.Moderation*), NULL - сообщение живое
+ALTER TABLE timeline ADD COLUMN msg_id TEXT;
+ALTER TABLE timeline ADD COLUMN moderation TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_timeline_msg_id ON timeline(msg_id);
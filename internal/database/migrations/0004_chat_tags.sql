@@ -0,0 +1,7 @@
+-- теги IRC у сообщений из чата, badges и emotes - json
+ALTER TABLE timeline ADD COLUMN user_id TEXT;
+ALTER TABLE timeline ADD COLUMN display_name TEXT;
+ALTER TABLE timeline ADD COLUMN color TEXT;
+ALTER TABLE timeline ADD COLUMN badges TEXT;
+ALTER TABLE timeline ADD COLUMN emotes TEXT;
+ALTER TABLE timeline ADD COLUMN reply_parent_id TEXT;
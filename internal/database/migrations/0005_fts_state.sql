@@ -0,0 +1,6 @@
+-- до какого id timeline уже проиндексирован в timeline_fts.
+-- саму fts5 таблицу создает search.go, только если sqlite собран с -tags sqlite_fts5
+CREATE TABLE IF NOT EXISTS fts_state (
+	name TEXT PRIMARY KEY,
+	last_id INTEGER NOT NULL DEFAULT 0
+);
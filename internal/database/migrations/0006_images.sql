@@ -0,0 +1,16 @@
+-- кеш описаний картинок: одну и ту же картинку нейронке второй раз не показываем.
+-- строка на каждый url, у перезаливов одной картинки одинаковый sha256
+CREATE TABLE IF NOT EXISTS images (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url TEXT NOT NULL,
+	sha256 TEXT NOT NULL,
+	mime TEXT,
+	width INTEGER,
+	height INTEGER,
+	description TEXT NOT NULL,
+	model TEXT,
+	created_at DATETIME NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_images_url ON images(url);
+CREATE INDEX IF NOT EXISTS idx_images_sha256 ON images(sha256);
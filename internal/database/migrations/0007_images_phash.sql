@@ -0,0 +1,3 @@
+-- перцептивный хеш (ollama.DHash), у пережатых и перезалитых копий он почти тот же.
+-- uint64 хранится как int64 с теми же битами, NULL - картинку не смогли раскодировать
+ALTER TABLE images ADD COLUMN phash INTEGER;
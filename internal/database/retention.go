@@ -0,0 +1,298 @@
+package database
+
+// сколько хранить события. правила в конфиге, например:
+//
+//	retention:
+//	  rules:
+//	    - {types: [chat], max_age: 2160h}         # чат всех каналов 90 дней
+//	    - {streamer: forsen, max_age: 720h}       # у forsen все 30 дней
+//	    - {types: [image]}                        # описания картинок навсегда
+//
+// на пару стример+тип действует самое точное правило: стример и тип > стример > тип > общее.
+// если ни одно не подошло - храним вечно. перед удалением можно сложить все в jsonl.gz.
+
+import (
+	"cmp"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/godovasik/dawgobot/internal/timeline"
+	"github.com/godovasik/dawgobot/logger"
+)
+
+type RetentionRule struct {
+	Streamer string        `yaml:"streamer"` // пусто - все каналы
+	Types    []string      `yaml:"types"`    // chat, image, sub... пусто - все типы
+	MaxAge   time.Duration `yaml:"max_age"`  // 0 - хранить вечно
+}
+
+type RetentionConfig struct {
+	Rules      []RetentionRule `yaml:"rules"`
+	Interval   time.Duration   `yaml:"interval"`    // как часто чистить в мониторе, 0 - не чистить
+	ArchiveDir string          `yaml:"archive_dir"` // куда складывать удаляемое, пусто - удалять без архива
+}
+
+func DefaultRetentionConfig() RetentionConfig {
+	return RetentionConfig{
+		Interval:   6 * time.Hour,
+		ArchiveDir: "archive",
+	}
+}
+
+// PruneGroup - сколько событий одного типа у одного стримера старше срока
+type PruneGroup struct {
+	Streamer string
+	Type     timeline.EventType
+	Cutoff   time.Time
+	Count    int
+}
+
+type PruneStats struct {
+	Groups  []PruneGroup
+	Deleted int
+	Archive string // файл архива, пусто если архива не было
+}
+
+// Prune удаляет события старше сроков из правил. dryRun - только посчитать
+func (db *DB) Prune(cfg RetentionConfig, dryRun bool) (PruneStats, error) {
+	var stats PruneStats
+
+	groups, err := db.pruneGroups(cfg.Rules, time.Now())
+	if err != nil || len(groups) == 0 {
+		return stats, err
+	}
+	stats.Groups = groups
+	if dryRun {
+		return stats, nil
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return stats, err
+	}
+	defer tx.Rollback()
+
+	// архив пишем в той же транзакции, что и удаление: не записалось - ничего не удаляем,
+	// не удалилось - недописанный архив тоже не нужен
+	var archive *gzip.Writer
+	var archiveFile *os.File
+	committed := false
+	if cfg.ArchiveDir != "" {
+		if err := os.MkdirAll(cfg.ArchiveDir, 0755); err != nil {
+			return stats, err
+		}
+		path := filepath.Join(cfg.ArchiveDir, fmt.Sprintf("timeline_%s.jsonl.gz", time.Now().Format("2006-01-02_15-04-05")))
+		archiveFile, err = os.Create(path)
+		if err != nil {
+			return stats, err
+		}
+		defer func() {
+			archiveFile.Close()
+			if !committed {
+				os.Remove(path)
+			}
+		}()
+		archive = gzip.NewWriter(archiveFile)
+		stats.Archive = path
+	}
+
+	var ftsLastID int64
+	if db.fts {
+		err := tx.QueryRow(`SELECT COALESCE(MAX(last_id), 0) FROM fts_state WHERE name = 'timeline'`).Scan(&ftsLastID)
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	for _, g := range groups {
+		cond := `streamer_name = ? AND event_type = ? AND timestamp < ?`
+		args := []any{g.Streamer, int(g.Type), g.Cutoff}
+
+		if archive != nil {
+			if err := archiveEvents(tx, archive, cond, args); err != nil {
+				return stats, fmt.Errorf("cant archive events: %w", err)
+			}
+		}
+
+		// external content fts5 сам не узнает об удалении, ему нужен старый текст
+		if db.fts {
+			_, err := tx.Exec(`
+				INSERT INTO timeline_fts (timeline_fts, rowid, content)
+				SELECT 'delete', id, content FROM timeline WHERE `+cond+` AND id <= ?`,
+				append(args, ftsLastID)...)
+			if err != nil {
+				return stats, fmt.Errorf("cant update search index: %w", err)
+			}
+		}
+
+		res, err := tx.Exec(`DELETE FROM timeline WHERE `+cond, args...)
+		if err != nil {
+			return stats, err
+		}
+		n, _ := res.RowsAffected()
+		stats.Deleted += int(n)
+	}
+
+	if archive != nil {
+		if err := archive.Close(); err != nil {
+			return stats, fmt.Errorf("cant write archive: %w", err)
+		}
+		if err := archiveFile.Sync(); err != nil {
+			return stats, fmt.Errorf("cant write archive: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return stats, err
+	}
+	committed = true
+	return stats, nil
+}
+
+// RunPruner чистит базу раз в cfg.Interval, первый раз сразу. Блокирует до отмены ctx
+func (db *DB) RunPruner(ctx context.Context, cfg RetentionConfig) {
+	if cfg.Interval <= 0 || len(cfg.Rules) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		stats, err := db.Prune(cfg, false)
+		switch {
+		case err != nil:
+			logger.Errorf("prune failed: %v", err)
+		case stats.Deleted > 0:
+			logger.Infof("pruned %d old events, archive: %s", stats.Deleted, cmp.Or(stats.Archive, "none"))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruneGroups - для каждой пары стример+тип из базы находит правило и считает, что под него попадает
+func (db *DB) pruneGroups(rules []RetentionRule, now time.Time) ([]PruneGroup, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	resolved := make([]resolvedRule, 0, len(rules))
+	for _, r := range rules {
+		rr := resolvedRule{streamer: strings.ToLower(r.Streamer), maxAge: r.MaxAge}
+		for _, name := range r.Types {
+			t, err := db.ParseEventType(name)
+			if err != nil {
+				return nil, fmt.Errorf("bad retention rule: %w", err)
+			}
+			rr.types = append(rr.types, t)
+		}
+		resolved = append(resolved, rr)
+	}
+
+	rows, err := db.conn.Query(`SELECT DISTINCT streamer_name, event_type FROM timeline`)
+	if err != nil {
+		return nil, err
+	}
+	var pairs []PruneGroup
+	for rows.Next() {
+		var g PruneGroup
+		var eventType int
+		if err := rows.Scan(&g.Streamer, &eventType); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		g.Type = timeline.EventType(eventType)
+		pairs = append(pairs, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var groups []PruneGroup
+	for _, g := range pairs {
+		rule, ok := matchRule(resolved, g.Streamer, g.Type)
+		if !ok || rule.maxAge <= 0 {
+			continue
+		}
+		g.Cutoff = now.Add(-rule.maxAge)
+
+		err := db.conn.QueryRow(`
+			SELECT COUNT(*) FROM timeline WHERE streamer_name = ? AND event_type = ? AND timestamp < ?`,
+			g.Streamer, int(g.Type), g.Cutoff).Scan(&g.Count)
+		if err != nil {
+			return nil, err
+		}
+		if g.Count > 0 {
+			groups = append(groups, g)
+		}
+	}
+	return groups, nil
+}
+
+type resolvedRule struct {
+	streamer string
+	types    []timeline.EventType
+	maxAge   time.Duration
+}
+
+// matchRule - самое точное правило для пары, при равной точности побеждает первое в конфиге
+func matchRule(rules []resolvedRule, streamer string, t timeline.EventType) (resolvedRule, bool) {
+	best, bestScore := resolvedRule{}, -1
+	for _, r := range rules {
+		score := 0
+		if r.streamer != "" {
+			if r.streamer != strings.ToLower(streamer) {
+				continue
+			}
+			score += 2
+		}
+		if len(r.types) > 0 {
+			if !containsType(r.types, t) {
+				continue
+			}
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = r, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+type archivedEvent struct {
+	ID int64 `json:"id"`
+	timeline.Event
+}
+
+func archiveEvents(tx *sql.Tx, w *gzip.Writer, cond string, args []any) error {
+	rows, err := tx.Query(`SELECT `+eventColumns+`, id FROM timeline WHERE `+cond+` ORDER BY id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	enc := json.NewEncoder(w)
+	for rows.Next() {
+		var id int64
+		event, err := scanEvent(rows, &id)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(archivedEvent{ID: id, Event: event}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
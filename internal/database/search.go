@@ -0,0 +1,161 @@
+package database
+
+// полнотекстовый поиск по timeline через sqlite FTS5.
+// fts5 есть только если собирать с тегом: go build -tags sqlite_fts5 ./cmd
+// без него бот работает как раньше, а SearchEvents возвращает ErrSearchUnavailable.
+//
+// индекс не на триггерах, а догоняется из Go после каждого AddEvents по fts_state.last_id:
+// триггер на fts5 таблицу сломал бы вставку у бинарника, собранного без тега.
+// поэтому же базу, которая писалась без fts5, индекс догонит при следующем запуске с ним.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/godovasik/dawgobot/internal/timeline"
+	"github.com/godovasik/dawgobot/logger"
+)
+
+var ErrSearchUnavailable = errors.New("full-text search unavailable, build with -tags sqlite_fts5")
+
+type SearchResult struct {
+	Event   timeline.Event
+	Snippet string // кусок сообщения, совпадения в [скобках]
+}
+
+// initFTS создает timeline_fts, если sqlite умеет fts5, и индексирует то что еще не проиндексировано
+func (db *DB) initFTS() error {
+	var enabled int
+	err := db.conn.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled)
+	if err != nil {
+		return err
+	}
+	if enabled == 0 {
+		logger.Info("sqlite built without fts5, search is disabled")
+		return nil
+	}
+
+	// external content: текст хранится только в timeline, в индексе лишь токены
+	_, err = db.conn.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS timeline_fts USING fts5(
+		content,
+		content = 'timeline',
+		content_rowid = 'id',
+		tokenize = 'unicode61 remove_diacritics 2'
+	)`)
+	if err != nil {
+		return fmt.Errorf("cant create fts table: %w", err)
+	}
+
+	db.fts = true
+	return db.syncFTS()
+}
+
+// syncFTS добавляет в индекс строки timeline после fts_state.last_id
+func (db *DB) syncFTS() error {
+	if !db.fts {
+		return nil
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lastID int64
+	err = tx.QueryRow(`SELECT COALESCE(MAX(last_id), 0) FROM fts_state WHERE name = 'timeline'`).Scan(&lastID)
+	if err != nil {
+		return err
+	}
+
+	var maxID int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM timeline`).Scan(&maxID); err != nil {
+		return err
+	}
+	if maxID <= lastID {
+		return nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO timeline_fts (rowid, content)
+		SELECT id, content FROM timeline WHERE id > ? AND id <= ?`, lastID, maxID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO fts_state (name, last_id) VALUES ('timeline', ?)
+		ON CONFLICT(name) DO UPDATE SET last_id = excluded.last_id`, maxID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if maxID-lastID > 100 {
+		logger.Infof("indexed %d events for search", maxID-lastID)
+	}
+	return nil
+}
+
+// SearchEvents ищет события по тексту, от старых к новым.
+// query - синтаксис FTS5 (слова, "фразы", OR, префикс*), для сырого ввода есть PhraseQuery.
+// пустой streamer и нулевые from/to - без фильтра, limit <= 0 - 50
+func (db *DB) SearchEvents(query, streamer string, from, to time.Time, limit int) ([]SearchResult, error) {
+	if !db.fts {
+		return nil, ErrSearchUnavailable
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+
+	where := []string{"1 = 1"}
+	args := []any{query}
+	if streamer != "" {
+		where = append(where, "streamer_name = ?")
+		args = append(args, streamer)
+	}
+	if !from.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, from)
+	}
+	if !to.IsZero() {
+		where = append(where, "timestamp <= ?")
+		args = append(args, to)
+	}
+	args = append(args, limit)
+
+	rows, err := db.conn.Query(`
+		SELECT `+eventColumns+`, snip
+		FROM timeline
+		JOIN (
+			SELECT rowid AS fts_id, snippet(timeline_fts, 0, '[', ']', '…', 16) AS snip
+			FROM timeline_fts WHERE timeline_fts MATCH ?
+		) ON id = fts_id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY timestamp ASC
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		result.Event, err = scanEvent(rows, &result.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// PhraseQuery превращает обычный текст в фразу FTS5, чтобы KEKW! или "-" не ломали запрос
+func PhraseQuery(text string) string {
+	return `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+}
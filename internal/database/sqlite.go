@@ -2,6 +2,13 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/godovasik/dawgobot/logger"
 	_ "github.com/mattn/go-sqlite3"
@@ -26,47 +33,128 @@ type EventType int
 
 type DB struct {
 	conn *sql.DB
+	fts  bool // есть timeline_fts, см. search.go
 }
 
-func New() (*DB, error) {
-	dbPath := "internal/database/db.sqlite"
-	conn, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
+// MemoryPath - база в памяти, живет пока открыт DB. удобно для тестов
+const MemoryPath = ":memory:"
+
+type Config struct {
+	Path        string        `yaml:"path"`         // файл базы или ":memory:"
+	BusyTimeout time.Duration `yaml:"busy_timeout"` // сколько ждать, если база залочена другим процессом
+	Create      bool          `yaml:"create"`       // создать пустую базу, если файла нет. migrate создает всегда
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Path:        "internal/database/db.sqlite",
+		BusyTimeout: 5 * time.Second,
+	}
+}
+
+// New открывает базу и догоняет схему до последней миграции
+func New(cfg Config) (*DB, error) {
+	db, err := Open(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	db := &DB{conn: conn}
-	if err := db.createTables(); err != nil {
-		conn.Close()
+	if _, err := db.Migrate(); err != nil {
+		db.Close()
 		return nil, err
 	}
+	if err := db.initFTS(); err != nil {
+		logger.Warnf("search is disabled: %v", err)
+	}
 	logger.Info("db initialized")
 
 	return db, nil
 }
 
-func (db *DB) createTables() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS timeline (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		streamer_name TEXT NOT NULL,
-		author TEXT,
-		event_type INTEGER NOT NULL,
-		content TEXT NOT NULL,
-		timestamp DATETIME NOT NULL
-	);
-
-	-- Составной индекс для быстрых запросов по стримеру и времени
-	CREATE INDEX IF NOT EXISTS idx_timeline_streamer_time 
-	ON timeline(streamer_name, timestamp);
-
-	-- Дополнительный индекс по типу событий для фильтрации
-	CREATE INDEX IF NOT EXISTS idx_timeline_event_type 
-	ON timeline(event_type);
-	`
-
-	_, err := db.conn.Exec(schema)
-	return err
+// Open открывает базу как есть, без миграций. нужна чтобы посмотреть версию схемы
+func Open(cfg Config) (*DB, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("database path is empty")
+	}
+
+	params := url.Values{}
+	params.Set("_foreign_keys", "on")
+	params.Set("_busy_timeout", strconv.FormatInt(cfg.BusyTimeout.Milliseconds(), 10))
+
+	memory := cfg.Path == MemoryPath
+	if !memory {
+		// WAL - монитор пишет, а migrate/search/export в это время могут читать
+		params.Set("_journal_mode", "WAL")
+
+		abs, err := filepath.Abs(cfg.Path)
+		if err != nil {
+			abs = cfg.Path
+		}
+		logger.Infof("opening db %s", abs)
+
+		// sqlite молча создает пустую базу на месте отсутствующей, и бот из другой папки
+		// тихо пишет историю не туда. поэтому создаем только если попросили явно
+		if _, err := os.Stat(cfg.Path); errors.Is(err, os.ErrNotExist) {
+			if !cfg.Create {
+				return nil, fmt.Errorf("database %s does not exist, create it with \"migrate up\" or set database.create", abs)
+			}
+			if err := os.MkdirAll(filepath.Dir(cfg.Path), 0755); err != nil {
+				return nil, fmt.Errorf("cant create db dir: %w", err)
+			}
+		}
+	}
+
+	conn, err := sql.Open("sqlite3", cfg.Path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if memory {
+		// у каждого соединения своя :memory: база, поэтому соединение одно
+		conn.SetMaxOpenConns(1)
+	}
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &DB{conn: conn}, nil
+}
+
+// ensureColumns добавляет в таблицу недостающие колонки. name -> тип
+func (db *DB) ensureColumns(table string, columns map[string]string) error {
+	rows, err := db.conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for name, typ := range columns {
+		if existing[name] {
+			continue
+		}
+		if _, err := db.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, typ)); err != nil {
+			return fmt.Errorf("cant add column %s.%s: %w", table, name, err)
+		}
+		logger.Infof("added column %s.%s", table, name)
+	}
+	return nil
 }
 
 func (db *DB) Close() error {
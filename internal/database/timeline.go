@@ -2,12 +2,16 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"time"
 
 	"github.com/godovasik/dawgobot/internal/timeline"
+	"github.com/godovasik/dawgobot/logger"
 )
 
 // AddEvents массово добавляет события в базу данных
@@ -23,33 +27,126 @@ func (db *DB) AddEvents(events []timeline.Event) error {
 	defer tx.Rollback()
 
 	stmt, err := tx.Prepare(`
-		INSERT INTO timeline (streamer_name, author, event_type, content, timestamp) 
-		VALUES (?, ?, ?, ?, ?)`)
+		INSERT INTO timeline (` + eventColumns + `) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	for _, event := range events {
+		meta, err := encodeJSON(event.Notice)
+		if err != nil {
+			return err
+		}
+		badges, err := encodeJSON(event.Badges)
+		if err != nil {
+			return err
+		}
+		emotes, err := encodeJSON(event.Emotes)
+		if err != nil {
+			return err
+		}
+
 		_, err = stmt.Exec(
 			event.Streamer,
 			event.Author,
 			int(event.Type),
 			event.Content,
 			event.Timestamp,
+			meta,
+			nullString(event.ID),
+			nullString(event.Moderation),
+			nullString(event.UserID),
+			nullString(event.DisplayName),
+			nullString(event.Color),
+			badges,
+			emotes,
+			nullString(event.ReplyParentID),
 		)
 		if err != nil {
 			return err
 		}
 	}
 
-	return tx.Commit()
+	// помечаем после вставки: удаленное сообщение может быть в этом же батче
+	for _, event := range events {
+		if err := applyModeration(tx, event); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// индекс для поиска догоняем отдельно, из-за него события терять не хочется
+	if err := db.syncFTS(); err != nil {
+		logger.Errorf("cant update search index: %v", err)
+	}
+	return nil
+}
+
+// moderationWindow - то же окно, что и у буфера в памяти
+const moderationWindow = timeline.ModerationWindow
+
+// applyModeration помечает сообщения, которые задело событие модерации, как timeline.Event.ModerationBy
+func applyModeration(tx *sql.Tx, event timeline.Event) error {
+	var res sql.Result
+	var err error
+
+	switch event.Type {
+	case timeline.EventMessageDeleted:
+		if event.Notice == nil || event.Notice.TargetMsgID == "" {
+			return nil
+		}
+		res, err = tx.Exec(`
+			UPDATE timeline SET moderation = ? 
+			WHERE streamer_name = ? AND msg_id = ?`,
+			timeline.ModerationDeleted, event.Streamer, event.Notice.TargetMsgID)
+
+	case timeline.EventTimeout, timeline.EventBan:
+		if event.Notice == nil || event.Notice.Target == "" {
+			return nil
+		}
+		mark := timeline.ModerationTimeout
+		if event.Type == timeline.EventBan {
+			mark = timeline.ModerationBan
+		}
+		res, err = tx.Exec(`
+			UPDATE timeline SET moderation = ? 
+			WHERE streamer_name = ? AND author = ? AND event_type IN (?, ?) 
+			AND moderation IS NULL AND timestamp BETWEEN ? AND ?`,
+			mark, event.Streamer, event.Notice.Target,
+			int(timeline.EventChat), int(timeline.EventImage),
+			event.Timestamp.Add(-moderationWindow), event.Timestamp)
+
+	case timeline.EventChatCleared:
+		res, err = tx.Exec(`
+			UPDATE timeline SET moderation = ? 
+			WHERE streamer_name = ? AND event_type IN (?, ?) 
+			AND moderation IS NULL AND timestamp BETWEEN ? AND ?`,
+			timeline.ModerationCleared, event.Streamer,
+			int(timeline.EventChat), int(timeline.EventImage),
+			event.Timestamp.Add(-moderationWindow), event.Timestamp)
+
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cant apply moderation: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n > 0 {
+		logger.Debugf("%s: marked %d events in %s", event.Content, n, event.Streamer)
+	}
+	return nil
 }
 
 // GetEventsByTimeRange возвращает события стримера за указанный временной промежуток
 func (db *DB) GetEventsByTimeRange(streamerName string, from, to time.Time) ([]timeline.Event, error) {
 	query := `
-		SELECT author, event_type, content, timestamp 
+		SELECT ` + eventColumns + ` 
 		FROM timeline 
 		WHERE streamer_name = ? AND timestamp BETWEEN ? AND ? 
 		ORDER BY timestamp ASC`
@@ -60,31 +157,12 @@ func (db *DB) GetEventsByTimeRange(streamerName string, from, to time.Time) ([]t
 	}
 	defer rows.Close()
 
-	var events []timeline.Event
-	for rows.Next() {
-		var event timeline.Event
-		var author sql.NullString
-		var eventType int
-
-		err := rows.Scan(&author, &eventType, &event.Content, &event.Timestamp)
-		if err != nil {
-			return nil, err
-		}
-
-		event.Streamer = streamerName
-		event.Type = timeline.EventType(eventType)
-		if author.Valid {
-			event.Author = author.String
-		}
-
-		events = append(events, event)
-	}
-
-	return events, rows.Err()
+	return scanEvents(rows)
 }
+
 func (db *DB) GetAllEventsByCount(count int) ([]timeline.Event, error) {
 	query := `
-		SELECT author, event_type, content, streamer_name, timestamp 
+		SELECT ` + eventColumns + ` 
 		FROM timeline 
 		ORDER BY timestamp DESC 
 		LIMIT ?`
@@ -95,42 +173,20 @@ func (db *DB) GetAllEventsByCount(count int) ([]timeline.Event, error) {
 	}
 	defer rows.Close()
 
-	var events []timeline.Event
-	for rows.Next() {
-		var event timeline.Event
-		var author sql.NullString
-		var streamerName sql.NullString
-		var eventType int
-
-		err := rows.Scan(&author, &eventType, &event.Content, &streamerName, &event.Timestamp)
-		if err != nil {
-			return nil, err
-		}
-
-		event.Type = timeline.EventType(eventType)
-		if author.Valid {
-			event.Author = author.String
-		}
-
-		if streamerName.Valid {
-			event.Streamer = streamerName.String
-		}
-
-		events = append(events, event)
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, err
 	}
 
 	// Разворачиваем массив, чтобы события шли в хронологическом порядке
-	for i := range len(events) / 2 {
-		events[i], events[len(events)-1-i] = events[len(events)-1-i], events[i]
-	}
-
-	return events, rows.Err()
+	slices.Reverse(events)
+	return events, nil
 }
 
 // GetEventsByCount возвращает последние N событий стримера
 func (db *DB) GetEventsByCount(streamerName string, count int) ([]timeline.Event, error) {
 	query := `
-		SELECT author, event_type, content, timestamp 
+		SELECT ` + eventColumns + ` 
 		FROM timeline 
 		WHERE streamer_name = ? 
 		ORDER BY timestamp DESC 
@@ -142,46 +198,112 @@ func (db *DB) GetEventsByCount(streamerName string, count int) ([]timeline.Event
 	}
 	defer rows.Close()
 
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// Разворачиваем массив, чтобы события шли в хронологическом порядке
+	slices.Reverse(events)
+	return events, nil
+}
+
+// GetVisibleEventsByCount - последние N событий стримера, которые остались в чате:
+// без удаленного модерами и без самих событий модерации, как их видит нейронка
+func (db *DB) GetVisibleEventsByCount(streamerName string, count int) ([]timeline.Event, error) {
+	rows, err := db.conn.Query(`
+		SELECT `+eventColumns+`
+		FROM timeline
+		WHERE streamer_name = ? AND moderation IS NULL AND event_type NOT IN (?, ?, ?, ?)
+		ORDER BY timestamp DESC
+		LIMIT ?`,
+		streamerName, int(timeline.EventTimeout), int(timeline.EventBan),
+		int(timeline.EventMessageDeleted), int(timeline.EventChatCleared), count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(events)
+	return events, nil
+}
+
+// eventColumns - колонки timeline в том порядке, в котором их читает scanEvents и пишет AddEvents
+const eventColumns = `streamer_name, author, event_type, content, timestamp, meta, msg_id, moderation, 
+		user_id, display_name, color, badges, emotes, reply_parent_id`
+
+// scanEvents читает строки, выбранные через eventColumns
+func scanEvents(rows *sql.Rows) ([]timeline.Event, error) {
 	var events []timeline.Event
 	for rows.Next() {
-		var event timeline.Event
-		var author sql.NullString
-		var eventType int
-
-		err := rows.Scan(&author, &eventType, &event.Content, &event.Timestamp)
+		event, err := scanEvent(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		event.Streamer = streamerName
-		event.Type = timeline.EventType(eventType)
-		if author.Valid {
-			event.Author = author.String
-		}
-
 		events = append(events, event)
 	}
 
-	// Разворачиваем массив, чтобы события шли в хронологическом порядке
-	for i := range len(events) / 2 {
-		events[i], events[len(events)-1-i] = events[len(events)-1-i], events[i]
+	return events, rows.Err()
+}
+
+// scanEvent читает одну строку eventColumns, extra - колонки, выбранные после них
+func scanEvent(rows *sql.Rows, extra ...any) (timeline.Event, error) {
+	var event timeline.Event
+	var eventType int
+	var (
+		streamerName, author, meta, msgID, moderation sql.NullString
+		userID, displayName, color, badges, emotes    sql.NullString
+		replyParentID                                 sql.NullString
+	)
+
+	dest := []any{
+		&streamerName, &author, &eventType, &event.Content, &event.Timestamp,
+		&meta, &msgID, &moderation,
+		&userID, &displayName, &color, &badges, &emotes, &replyParentID,
+	}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return event, err
 	}
 
-	return events, rows.Err()
+	event.Streamer = streamerName.String
+	event.Author = author.String
+	event.Type = timeline.EventType(eventType)
+	event.ID = msgID.String
+	event.Moderation = moderation.String
+	event.UserID = userID.String
+	event.DisplayName = displayName.String
+	event.Color = color.String
+	event.ReplyParentID = replyParentID.String
+
+	if err := decodeJSON(meta, &event.Notice); err != nil {
+		return event, err
+	}
+	if err := decodeJSON(badges, &event.Badges); err != nil {
+		return event, err
+	}
+	if err := decodeJSON(emotes, &event.Emotes); err != nil {
+		return event, err
+	}
+	return event, nil
 }
 
-// ExportEventsByTimeRangeToFile экспортирует события в текстовый файл
-func (db *DB) ExportEventsByTimeRangeToFile(streamerName string, from, to time.Time) (string, error) {
+// ExportEventsByTimeRangeToFile экспортирует события в текстовый файл.
+// anon - чистка персональных данных, nil - выгрузить как есть
+func (db *DB) ExportEventsByTimeRangeToFile(streamerName string, from, to time.Time, anon *Anonymizer) (string, error) {
 	events, err := db.GetEventsByTimeRange(streamerName, from, to)
 	if err != nil {
 		return "", err
 	}
 
-	return db.exportEventsToFile(events, streamerName, from, to)
+	return db.exportEventsToFile(events, streamerName, from, to, anon)
 }
 
 // ExportEventsByCountToFile экспортирует последние N событий в текстовый файл
-func (db *DB) ExportEventsByCountToFile(streamerName string, count int) (string, error) {
+func (db *DB) ExportEventsByCountToFile(streamerName string, count int, anon *Anonymizer) (string, error) {
 	events, err := db.GetEventsByCount(streamerName, count)
 	if err != nil {
 		return "", err
@@ -197,7 +319,7 @@ func (db *DB) ExportEventsByCountToFile(streamerName string, count int) (string,
 		to = now
 	}
 
-	return db.exportEventsToFile(events, streamerName, from, to)
+	return db.exportEventsToFile(events, streamerName, from, to, anon)
 }
 
 func (db *DB) GetEventsCountByStreamer(streamerName string) (int, error) {
@@ -213,7 +335,7 @@ func (db *DB) GetEventsCountByStreamer(streamerName string) (int, error) {
 }
 
 // exportEventsToFile внутренняя функция для экспорта событий в файл
-func (db *DB) exportEventsToFile(events []timeline.Event, streamerName string, from, to time.Time) (string, error) {
+func (db *DB) exportEventsToFile(events []timeline.Event, streamerName string, from, to time.Time, anon *Anonymizer) (string, error) {
 	// Создаем папку если не существует
 	logsDir := "./logs/events"
 	if err := os.MkdirAll(logsDir, 0755); err != nil {
@@ -232,8 +354,12 @@ func (db *DB) exportEventsToFile(events []timeline.Event, streamerName string, f
 	}
 	defer file.Close()
 
-	// Записываем события в файл
+	// Записываем события в файл, удаленное модерами не экспортируем
 	for _, event := range events {
+		if event.Moderation != "" || anon.Drop(event) {
+			continue
+		}
+		event = anon.Event(event)
 		line := db.formatEventLine(event)
 		if _, err := file.WriteString(line + "\n"); err != nil {
 			return "", err
@@ -265,9 +391,60 @@ func (db *DB) eventTypeToString(eventType timeline.EventType) string {
 		return "CHAT"
 	case timeline.EventSpeech:
 		return "SPEECH"
+	case timeline.EventImage:
+		return "IMAGE"
 	case timeline.EventScreenshot:
 		return "SCREENSHOT"
+	case timeline.EventSub:
+		return "SUB"
+	case timeline.EventResub:
+		return "RESUB"
+	case timeline.EventSubGift:
+		return "SUBGIFT"
+	case timeline.EventRaid:
+		return "RAID"
+	case timeline.EventAnnouncement:
+		return "ANNOUNCEMENT"
+	case timeline.EventTimeout:
+		return "TIMEOUT"
+	case timeline.EventBan:
+		return "BAN"
+	case timeline.EventMessageDeleted:
+		return "DELETED"
+	case timeline.EventChatCleared:
+		return "CLEAR"
 	default:
 		return "UNKNOWN"
 	}
 }
+
+// encodeJSON - значение в json для текстовой колонки, пустое пишется как NULL
+func encodeJSON[T any](v T) (any, error) {
+	rv := reflect.ValueOf(v)
+	switch {
+	case !rv.IsValid() || rv.IsZero():
+		return nil, nil
+	case (rv.Kind() == reflect.Map || rv.Kind() == reflect.Slice) && rv.Len() == 0:
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("cant marshal %T: %w", v, err)
+	}
+	return string(data), nil
+}
+
+func decodeJSON(data sql.NullString, v any) error {
+	if !data.Valid || data.String == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(data.String), v); err != nil {
+		return fmt.Errorf("cant unmarshal %T: %w", v, err)
+	}
+	return nil
+}
+
+// nullString - пустую строку пишем как NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,245 @@
+package fetch
+
+// скачивание всего, на что кидают ссылки в чате. ссылки присылает кто угодно, поэтому:
+//   - адрес проверяется после резолва dns, прямо при подключении: в локалку, на localhost
+//     и link-local (169.254.169.254 и прочие метаданные облаков) не ходим, даже через редирект
+//     или домен, который резолвится в 127.0.0.1;
+//   - редиректов не больше MaxRedirects и только на http/https;
+//   - тело читается не больше MaxBytes, на все про все Timeout;
+//   - картинка это или нет, решают первые байты, а не Content-Type от сервера.
+// прокси из окружения не используется: через него проверка адреса теряет смысл.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrNotAnImage = errors.New("it's not an image")
+	ErrBlocked    = errors.New("address is not allowed")
+	ErrTooLarge   = errors.New("response is too large")
+	ErrBadURL     = errors.New("bad url")
+)
+
+type Config struct {
+	Timeout      time.Duration `yaml:"timeout"`       // на весь запрос вместе с редиректами и телом
+	MaxBytes     int64         `yaml:"max_bytes"`     // больше не качаем
+	MaxRedirects int           `yaml:"max_redirects"` // 0 - редиректы не ходим
+	AllowPrivate bool          `yaml:"allow_private"` // пускать в локальные сети, только для отладки
+	UserAgent    string        `yaml:"user_agent"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Timeout:      15 * time.Second,
+		MaxBytes:     20 << 20,
+		MaxRedirects: 3,
+		UserAgent:    "dawgobot/1.0",
+	}
+}
+
+type Fetcher struct {
+	cfg    Config
+	client *http.Client
+}
+
+// Default - фетчер, через который ходят CheckUrl, GetImage и клиент бота. меняется через SetDefault
+var Default = New(DefaultConfig())
+
+// SetDefault заменяет Default, вызывать до старта бота
+func SetDefault(cfg Config) {
+	Default = New(cfg)
+}
+
+func New(cfg Config) *Fetcher {
+	def := DefaultConfig()
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = def.Timeout
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = def.MaxBytes
+	}
+	if cfg.MaxRedirects < 0 {
+		cfg.MaxRedirects = 0
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = def.UserAgent
+	}
+
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		// Control вызывается уже с ip после резолва, на каждое подключение, включая редиректы
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if cfg.AllowPrivate {
+				return nil
+			}
+			return checkAddress(address)
+		},
+	}
+
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &Fetcher{
+		cfg: cfg,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > cfg.MaxRedirects {
+					return fmt.Errorf("stopped after %d redirects", cfg.MaxRedirects)
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("%w: redirect to %s", ErrBadURL, req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// NormalizeURL добавляет https:// к голым доменам из чата и пропускает только http/https
+func NormalizeURL(raw string) (string, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrBadURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%w: scheme %s", ErrBadURL, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("%w: no host in %s", ErrBadURL, raw)
+	}
+	return u.String(), nil
+}
+
+// IsImage - картинка ли по ссылке, без скачивания целиком. share-страницы тут не разворачиваются,
+// для них ResolveImage. окончательно проверяет Image по байтам
+func (f *Fetcher) IsImage(ctx context.Context, rawURL string) (bool, error) {
+	u, err := NormalizeURL(rawURL)
+	if err != nil {
+		return false, err
+	}
+	kind, _, err := f.probe(ctx, u)
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(kind, "image/"), nil
+}
+
+// Image качает картинку. ErrNotAnImage если по байтам это не картинка, какой бы ни был Content-Type
+func (f *Fetcher) Image(ctx context.Context, rawURL string) ([]byte, error) {
+	data, err := f.Get(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(http.DetectContentType(data), "image/") {
+		return nil, ErrNotAnImage
+	}
+	return data, nil
+}
+
+// Get качает тело целиком, но не больше MaxBytes
+func (f *Fetcher) Get(ctx context.Context, rawURL string) ([]byte, error) {
+	resp, err := f.do(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	if resp.ContentLength > f.cfg.MaxBytes {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, resp.ContentLength)
+	}
+
+	// Content-Length может врать или отсутствовать, поэтому лимит еще и на чтении
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.cfg.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > f.cfg.MaxBytes {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, f.cfg.MaxBytes)
+	}
+	return data, nil
+}
+
+func (f *Fetcher) do(ctx context.Context, method, rawURL string, header http.Header) (*http.Response, error) {
+	u, err := NormalizeURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", f.cfg.UserAgent)
+	return f.client.Do(req)
+}
+
+// checkAddress - address это ip:port, который уже получился после резолва
+func checkAddress(address string) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlocked, address)
+	}
+	if !isPublic(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrBlocked, addrPort.Addr())
+	}
+	return nil
+}
+
+// диапазоны, для которых в net/netip нет методов
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "эта сеть", на линуксе 0.x.x.x уходит в localhost
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade nat
+	netip.MustParsePrefix("198.18.0.0/15"), // стенды для бенчмарков, бывает внутри датацентров
+	netip.MustParsePrefix("240.0.0.0/4"),   // зарезервировано, вместе с 255.255.255.255
+	netip.MustParsePrefix("64:ff9b::/96"),  // nat64: 64:ff9b::7f00:1 на таких хостах это 127.0.0.1
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2002::/16"), // 6to4, внутри лежит ipv4: 2002:7f00:1:: ведет на 127.0.0.1
+	netip.MustParsePrefix("2001::/32"), // teredo, тоже туннель с ipv4 внутри
+	netip.MustParsePrefix("fec0::/10"), // site-local, устарел, но кое-где еще внутренняя сеть
+}
+
+func isPublic(ip netip.Addr) bool {
+	ip = ip.Unmap() // ::ffff:127.0.0.1 это тоже localhost
+	if !ip.IsValid() ||
+		ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package fetch
+
+// как понять, что по ссылке картинка, и найти ее, если ссылка на страницу.
+// HEAD многие хостинги не любят (405, text/html, пустой Content-Type), поэтому после него
+// качаем первые байты через Range и смотрим на них сами. если там html - ищем og:image,
+// так разворачиваются imgur, reddit и прочие страницы с превью. твиты отдаются через
+// d.fxtwitter.com: сам x.com без js ничего не показывает, а fxtwitter редиректит прямо на картинку.
+
+import (
+	"context"
+	"errors"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	sniffBytes = 512       // столько смотрит http.DetectContentType
+	pageBytes  = 512 << 10 // og теги в <head>, дальше страницу не читаем
+)
+
+// ResolveImage - прямая ссылка на картинку по ссылке из чата. ok=false если картинки там нет
+func (f *Fetcher) ResolveImage(ctx context.Context, rawURL string) (string, bool, error) {
+	u, err := NormalizeURL(rawURL)
+	if err != nil {
+		return "", false, err
+	}
+	u = rewriteShareURL(u)
+
+	kind, final, err := f.probe(ctx, u)
+	if err != nil {
+		return "", false, err
+	}
+	if strings.HasPrefix(kind, "image/") {
+		return final, true, nil
+	}
+	if !strings.HasPrefix(kind, "text/html") {
+		return "", false, nil
+	}
+
+	imageURL, err := f.pageImage(ctx, final)
+	if err != nil || imageURL == "" {
+		return "", false, err
+	}
+	// og:image проверяем так же, но без второго круга по страницам
+	kind, final, err = f.probe(ctx, imageURL)
+	if err != nil {
+		return "", false, err
+	}
+	return final, strings.HasPrefix(kind, "image/"), nil
+}
+
+// probe - тип содержимого и адрес после редиректов. сначала HEAD, если он не сказал image/* - ranged GET
+func (f *Fetcher) probe(ctx context.Context, u string) (kind, final string, err error) {
+	resp, err := f.do(ctx, http.MethodHead, u, nil)
+	if errors.Is(err, ErrBlocked) || errors.Is(err, ErrBadURL) {
+		return "", "", err
+	}
+	if err == nil {
+		resp.Body.Close()
+		kind = resp.Header.Get("Content-Type")
+		if resp.StatusCode/100 == 2 && strings.HasPrefix(kind, "image/") {
+			return kind, resp.Request.URL.String(), nil
+		}
+	}
+
+	resp, err = f.do(ctx, http.MethodGet, u, http.Header{"Range": {"bytes=0-511"}})
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return "", "", errors.New("unexpected status: " + resp.Status)
+	}
+
+	head, err := io.ReadAll(io.LimitReader(resp.Body, sniffBytes))
+	if err != nil {
+		return "", "", err
+	}
+	final = resp.Request.URL.String()
+
+	kind = http.DetectContentType(head)
+	if !strings.HasPrefix(kind, "image/") && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		// страница может начинаться не с <html>, тогда сниффер скажет text/plain
+		kind = "text/html"
+	}
+	return kind, final, nil
+}
+
+var (
+	metaTagRegex = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrRegex    = regexp.MustCompile(`(?s)([a-zA-Z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// og:image важнее twitter:image, остальное в порядке появления
+var imageMetaNames = []string{"og:image:secure_url", "og:image:url", "og:image", "twitter:image", "twitter:image:src"}
+
+// pageImage - картинка из og тегов страницы, пусто если ее нет
+func (f *Fetcher) pageImage(ctx context.Context, pageURL string) (string, error) {
+	resp, err := f.do(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil
+	}
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, pageBytes))
+	if err != nil {
+		return "", err
+	}
+
+	found := make(map[string]string)
+	for _, tag := range metaTagRegex.FindAllString(string(page), -1) {
+		attrs := make(map[string]string)
+		for _, m := range attrRegex.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(m[1])] = m[2] + m[3]
+		}
+		name := strings.ToLower(attrs["property"])
+		if name == "" {
+			name = strings.ToLower(attrs["name"])
+		}
+		if _, ok := found[name]; !ok && attrs["content"] != "" {
+			found[name] = html.UnescapeString(strings.TrimSpace(attrs["content"]))
+		}
+	}
+
+	for _, name := range imageMetaNames {
+		if content, ok := found[name]; ok {
+			// бывают относительные ссылки и //cdn.example.com/...
+			ref, err := url.Parse(content)
+			if err != nil {
+				continue
+			}
+			return resp.Request.URL.ResolveReference(ref).String(), nil
+		}
+	}
+	return "", nil
+}
+
+var twitterHosts = map[string]bool{
+	"twitter.com": true, "mobile.twitter.com": true, "x.com": true,
+	"fxtwitter.com": true, "vxtwitter.com": true, "fixupx.com": true,
+}
+
+// rewriteShareURL - ссылки, у которых og теги без js не достать, на сервисы, которые их отдают
+func rewriteShareURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+
+	// x.com/user/status/123 -> d.fxtwitter.com/user/status/123, редирект на саму картинку
+	if twitterHosts[host] && strings.Contains(u.Path, "/status/") {
+		u.Scheme, u.Host, u.RawQuery = "https", "d.fxtwitter.com", ""
+		return u.String()
+	}
+	return rawURL
+}
@@ -43,6 +43,25 @@ const (
 	EventImage
 	EventSpeech
 	EventScreenshot
+	// USERNOTICE из твича
+	EventSub
+	EventResub
+	EventSubGift
+	EventRaid
+	EventAnnouncement
+	// CLEARCHAT и CLEARMSG, исходные сообщения в базе помечаются через Moderation
+	EventTimeout
+	EventBan
+	EventMessageDeleted
+	EventChatCleared
+)
+
+// чем помечены сообщения, которые удалили модеры
+const (
+	ModerationDeleted = "deleted"
+	ModerationTimeout = "timeout"
+	ModerationBan     = "ban"
+	ModerationCleared = "cleared"
 )
 
 // Структура события
@@ -52,6 +71,85 @@ type Event struct {
 	Author    string // для чата
 	Streamer  string
 	Timestamp time.Time
+
+	ID         string  // id сообщения в твиче, по нему CLEARMSG находит что удалили
+	Moderation string  // не пусто - сообщение удалили модеры, см. Moderation*
+	Notice     *Notice // только у сабок, рейдов, банов и т.д., в базе лежит json-ом в колонке meta
+
+	// остальное из тегов IRC, есть только у сообщений из чата
+	UserID        string
+	DisplayName   string
+	Color         string
+	Badges        map[string]int // бейдж -> версия, например subscriber -> 12
+	Emotes        []Emote
+	ReplyParentID string // на какое сообщение это ответ
+}
+
+// ModerationWindow - за сколько до таймаута или бана сообщения юзера считаются удаленными.
+// твич чистит только то что видно в чате, так что старые сообщения не трогаем
+const ModerationWindow = 10 * time.Minute
+
+// IsModeration - служебное событие модерации, а не сообщение
+func (e Event) IsModeration() bool {
+	switch e.Type {
+	case EventTimeout, EventBan, EventMessageDeleted, EventChatCleared:
+		return true
+	}
+	return false
+}
+
+// ModerationBy - какой пометкой событие модерации mod задевает e, пусто - не задевает.
+// то же самое для базы делает database.applyModeration
+func (e Event) ModerationBy(mod Event) string {
+	if e.Moderation != "" || e.Streamer != mod.Streamer {
+		return ""
+	}
+	if mod.Type == EventMessageDeleted {
+		if mod.Notice != nil && mod.Notice.TargetMsgID != "" && e.ID == mod.Notice.TargetMsgID {
+			return ModerationDeleted
+		}
+		return ""
+	}
+
+	inWindow := !e.Timestamp.Before(mod.Timestamp.Add(-ModerationWindow)) && !e.Timestamp.After(mod.Timestamp)
+	if (e.Type != EventChat && e.Type != EventImage) || !inWindow {
+		return ""
+	}
+	switch mod.Type {
+	case EventTimeout, EventBan:
+		if mod.Notice == nil || !strings.EqualFold(e.Author, mod.Notice.Target) {
+			return ""
+		}
+		if mod.Type == EventBan {
+			return ModerationBan
+		}
+		return ModerationTimeout
+	case EventChatCleared:
+		return ModerationCleared
+	}
+	return ""
+}
+
+// Emote - где в Content стоит эмоут, индексы в рунах, End включительно
+type Emote struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Notice - подробности USERNOTICE и модерации, пустые поля не пишутся
+type Notice struct {
+	Months    int    `json:"months,omitempty"`     // сколько месяцев сабка всего
+	Tier      string `json:"tier,omitempty"`       // 1000, 2000, 3000 или Prime
+	Recipient string `json:"recipient,omitempty"`  // кому подарили сабку
+	GiftCount int    `json:"gift_count,omitempty"` // сколько сабок подарили разом
+	Raider    string `json:"raider,omitempty"`
+	Viewers   int    `json:"viewers,omitempty"` // сколько зрителей привел рейд
+
+	Target      string `json:"target,omitempty"`        // кого забанили или чье сообщение удалили
+	Duration    int    `json:"duration,omitempty"`      // таймаут в секундах
+	TargetMsgID string `json:"target_msg_id,omitempty"` // какое сообщение удалили
 }
 
 // Циркулярный буфер
@@ -98,6 +196,22 @@ func (cb *CircularBuffer) Add(event Event) {
 	cb.tail = (cb.tail + 1) % cb.size
 }
 
+// Moderate помечает события в буфере, которые задело событие модерации
+func (cb *CircularBuffer) Moderate(mod Event) int {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	marked := 0
+	// пустые ячейки не совпадут по стримеру, так что можно идти по всему массиву
+	for i := range cb.events {
+		if mark := cb.events[i].ModerationBy(mod); mark != "" {
+			cb.events[i].Moderation = mark
+			marked++
+		}
+	}
+	return marked
+}
+
 // Получить все события (от старых к новым)
 func (cb *CircularBuffer) GetAll() []Event {
 	cb.mutex.RLock()
@@ -186,6 +300,11 @@ func (tl *Timeline) processEvents() {
 	for {
 		select {
 		case event := <-tl.eventChan:
+			// сообщения модерации приходят после самих сообщений, через тот же канал,
+			// так что к этому моменту удаленное уже лежит в буфере
+			if event.IsModeration() {
+				tl.buffer.Moderate(event)
+			}
 			tl.buffer.Add(event)
 		case <-tl.stopChan:
 			return
@@ -202,6 +321,23 @@ func (tl *Timeline) GetLastEvents(n int) []Event {
 	return tl.buffer.GetLast(n)
 }
 
+// GetLastStreamerEvents - последние n событий одного канала, которые остались в чате:
+// без удаленного модерами и без самих событий модерации
+func (tl *Timeline) GetLastStreamerEvents(streamer string, n int) []Event {
+	all := tl.buffer.GetAll()
+	var result []Event
+	for i := len(all) - 1; i >= 0 && len(result) < n; i-- {
+		if all[i].Streamer == streamer && all[i].Moderation == "" && !all[i].IsModeration() {
+			result = append(result, all[i])
+		}
+	}
+	// собирали с конца, разворачиваем в хронологический порядок
+	for i := range len(result) / 2 {
+		result[i], result[len(result)-1-i] = result[len(result)-1-i], result[i]
+	}
+	return result
+}
+
 func (tl *Timeline) GetRecentEvents(duration time.Duration) []Event {
 	return tl.buffer.GetRecent(duration)
 }
@@ -225,12 +361,23 @@ func NewEventMock() func() Event {
 	}
 }
 
-// TODO: доделать
+// SprintEvents - события в виде переписки, так их видит нейронка
 func SprintEvents(events []Event) string {
 	sb := strings.Builder{}
 	for _, e := range events {
-		sb.WriteString(fmt.Sprintf("%s: %s\n", e.Author, e.Content))
-
+		if e.Moderation != "" {
+			continue // удаленное модерами нейронка видеть не должна
+		}
+		switch e.Type {
+		case EventGlobal, EventTimeout, EventBan, EventMessageDeleted, EventChatCleared:
+			continue // служебные события нейронке не нужны
+		case EventImage:
+			sb.WriteString(fmt.Sprintf("%s скинул картинку: %s\n", e.Author, e.Content))
+		case EventSub, EventResub, EventSubGift, EventRaid, EventAnnouncement:
+			sb.WriteString(fmt.Sprintf("* %s\n", e.Content)) // system-msg уже с ником
+		default:
+			sb.WriteString(fmt.Sprintf("%s: %s\n", e.Author, e.Content))
+		}
 	}
 	return sb.String()
 }
@@ -12,6 +12,9 @@ import (
 	"github.com/godovasik/dawgobot/logger"
 )
 
+// BotName - ник бота в твиче, от него пишем и на его теги отвечаем
+const BotName = "dawgobot"
+
 type Client struct {
 	TWClient *tw.Client
 
@@ -37,7 +40,7 @@ func NewClient() (*Client, error) {
 		return nil, fmt.Errorf("variable TWITCH_CLIENT_SECRET is not set")
 	}
 
-	twClient := tw.NewClient("dawgobot", fmt.Sprintf("oauth:%s", accessToken))
+	twClient := tw.NewClient(BotName, fmt.Sprintf("oauth:%s", accessToken))
 
 	client := &Client{
 		TWClient:     twClient,
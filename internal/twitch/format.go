@@ -0,0 +1,167 @@
+package twitch
+
+// нейронка любит отвечать простынями с markdown, а твич молча режет все что длиннее 500 символов.
+// FormatMessage чистит разметку и режет ответ на куски по границам предложений или слов.
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxMessageLength - лимит твича на одно сообщение, в символах а не байтах
+const MaxMessageLength = 500
+
+type FormatConfig struct {
+	MaxLength   int  `yaml:"max_length"`   // длина одного сообщения, не больше MaxMessageLength
+	MaxParts    int  `yaml:"max_parts"`    // сколько сообщений максимум на один ответ, 0 - без лимита
+	NumberParts bool `yaml:"number_parts"` // дописывать (1/3) в начало каждого куска
+}
+
+// Validate - после "(3/3) " и "…" в сообщении должно оставаться место под текст
+func (c FormatConfig) Validate() error {
+	if c.MaxLength < 0 {
+		return fmt.Errorf("max_length must not be negative, got %d", c.MaxLength)
+	}
+	if c.MaxParts < 0 {
+		return fmt.Errorf("max_parts must not be negative, got %d", c.MaxParts)
+	}
+	limit := c.maxLength()
+	reserved := utf8.RuneCountInString("…")
+	if c.NumberParts {
+		reserved += len(c.partPrefix())
+	}
+	if limit <= reserved {
+		return fmt.Errorf("max_length %d leaves no room for text, need more than %d", limit, reserved)
+	}
+	return nil
+}
+
+// maxLength - MaxLength с учетом лимита твича, 0 - MaxMessageLength
+func (c FormatConfig) maxLength() int {
+	if c.MaxLength <= 0 || c.MaxLength > MaxMessageLength {
+		return MaxMessageLength
+	}
+	return c.MaxLength
+}
+
+// partPrefix - самый длинный из возможных "(i/n) ", номер последнего куска заранее неизвестен
+func (c FormatConfig) partPrefix() string {
+	total := c.MaxParts
+	if total <= 0 {
+		total = 99
+	}
+	return fmt.Sprintf("(%d/%d) ", total, total)
+}
+
+func DefaultFormatConfig() FormatConfig {
+	return FormatConfig{
+		MaxLength:   MaxMessageLength,
+		MaxParts:    3,
+		NumberParts: true,
+	}
+}
+
+var (
+	mdLink     = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]+)\)`)    // [текст](url)
+	mdHeader   = regexp.MustCompile(`(?m)^\s{0,3}#{1,6}\s+`)        // # заголовок
+	mdListItem = regexp.MustCompile(`(?m)^\s*(?:[-*+]|\d+[.)])\s+`) // - пункт, 1. пункт
+	mdQuote    = regexp.MustCompile(`(?m)^\s*>\s?`)                 // > цитата
+	mdEmphasis = regexp.MustCompile("\\*{1,3}|_{2,3}|~~|`{1,3}")    // **жирный**, __такой__, `код`
+	spaces     = regexp.MustCompile(`\s+`)
+)
+
+// StripMarkdown убирает разметку и переносы строк, в чате твича их все равно не видно
+func StripMarkdown(text string) string {
+	text = mdLink.ReplaceAllString(text, "$1 $2")
+	text = mdHeader.ReplaceAllString(text, "")
+	text = mdListItem.ReplaceAllString(text, "")
+	text = mdQuote.ReplaceAllString(text, "")
+	text = mdEmphasis.ReplaceAllString(text, "")
+	return strings.TrimSpace(spaces.ReplaceAllString(text, " "))
+}
+
+// FormatMessage готовит ответ к отправке: чистит разметку и режет на куски.
+// если кусков больше MaxParts - последний обрезается и заканчивается на "…"
+func FormatMessage(text string, cfg FormatConfig) []string {
+	text = StripMarkdown(text)
+	if text == "" {
+		return nil
+	}
+
+	limit := cfg.maxLength()
+
+	if utf8.RuneCountInString(text) <= limit {
+		return []string{text}
+	}
+
+	// место под "(1/3) " резервируем заранее. конфиг проверяется в Validate,
+	// но если номер все равно не влезает - режем без номеров, чем падать
+	numbered := cfg.NumberParts && limit-len(cfg.partPrefix()) > 1
+	if numbered {
+		limit -= len(cfg.partPrefix())
+	}
+
+	var parts []string
+	for text != "" {
+		if cfg.MaxParts > 0 && len(parts) == cfg.MaxParts-1 {
+			parts = append(parts, truncate(text, limit))
+			break
+		}
+		part, rest := splitAt(text, limit)
+		parts = append(parts, part)
+		text = rest
+	}
+
+	if numbered && len(parts) > 1 {
+		for i := range parts {
+			parts[i] = fmt.Sprintf("(%d/%d) %s", i+1, len(parts), parts[i])
+		}
+	}
+	return parts
+}
+
+// splitAt отрезает от text кусок не длиннее limit символов.
+// сначала ищем конец предложения, потом пробел, если ничего нет - режем как есть
+func splitAt(text string, limit int) (part, rest string) {
+	limit = max(limit, 1) // пустой кусок зациклил бы FormatMessage
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text, ""
+	}
+
+	cut := -1
+	// предложение, которое короче половины лимита, не считается - слишком мелко нарежем
+	for i := limit - 1; i >= limit/2; i-- {
+		if strings.ContainsRune(".!?…", runes[i]) && runes[i+1] == ' ' {
+			cut = i + 1
+			break
+		}
+	}
+	if cut == -1 {
+		for i := limit; i > 0; i-- {
+			if runes[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+	}
+	if cut == -1 {
+		cut = limit
+	}
+
+	return strings.TrimSpace(string(runes[:cut])), strings.TrimSpace(string(runes[cut:]))
+}
+
+// truncate обрезает text по слову, чтобы вместе с "…" влезло в limit
+func truncate(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	if limit <= 1 {
+		return "…"
+	}
+	part, _ := splitAt(text, limit-1)
+	return strings.TrimRight(part, " .,;:") + "…"
+}
@@ -0,0 +1,264 @@
+package twitch
+
+// Outbox - все исходящие сообщения бота идут через него.
+// Твич пускает 100 сообщений за 30 секунд, но в каналы где бот не модер - только 20,
+// за превышение можно словить глобальный шедоубан, поэтому:
+//   - кулдауны на юзера и на канал - бот не отвечает на каждую картинку в спаме
+//   - очередь с ограничением скорости, из нее выкидываются сообщения старше MaxAge,
+//     отвечать на картинку через минуту уже никому не надо
+//   - длинные ответы режутся на куски через FormatMessage, кулдаун тратится один на весь ответ
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	tw "github.com/gempir/go-twitch-irc/v4"
+	"github.com/godovasik/dawgobot/logger"
+)
+
+// лимиты твича на отправку сообщений
+const (
+	rateWindow    = 30 * time.Second
+	rateLimitUser = 20
+	rateLimitMod  = 100
+)
+
+type OutboxConfig struct {
+	UserCooldown    time.Duration `yaml:"user_cooldown"`    // как часто отвечаем одному человеку в канале
+	ChannelCooldown time.Duration `yaml:"channel_cooldown"` // как часто вообще пишем в канал
+	MaxAge          time.Duration `yaml:"max_age"`          // сообщения старше этого выкидываются из очереди
+	QueueSize       int           `yaml:"queue_size"`
+
+	Format FormatConfig `yaml:"format"`
+}
+
+func DefaultOutboxConfig() OutboxConfig {
+	return OutboxConfig{
+		UserCooldown:    30 * time.Second,
+		ChannelCooldown: 5 * time.Second,
+		MaxAge:          30 * time.Second,
+		QueueSize:       50,
+		Format:          DefaultFormatConfig(),
+	}
+}
+
+type outMessage struct {
+	reply    uint64 // номер ответа, у всех кусков одного ответа одинаковый
+	channel  string
+	parentID string // пустой - обычное сообщение, иначе ответ
+	text     string
+	queuedAt time.Time
+}
+
+type Outbox struct {
+	irc   *tw.Client
+	cfg   OutboxConfig
+	queue chan outMessage
+
+	mu          sync.Mutex
+	sent        []time.Time // все отправленные за последние rateWindow, лимит rateLimitMod
+	sentUser    []time.Time // отправленные в каналы где бот не модер, лимит rateLimitUser
+	modIn       map[string]bool
+	lastUser    map[string]time.Time // channel/user -> когда последний раз отвечали
+	lastChannel map[string]time.Time
+	lastCommand map[string]time.Time // channel/user -> когда последний раз отвечали на команду
+	replies     uint64
+}
+
+// NewOutbox подписывается на USERSTATE, чтобы знать в каких каналах бот модер.
+// Сообщения начнут уходить только после Run.
+func NewOutbox(irc *tw.Client, cfg OutboxConfig) *Outbox {
+	o := &Outbox{
+		irc:         irc,
+		cfg:         cfg,
+		queue:       make(chan outMessage, max(cfg.QueueSize, 1)),
+		modIn:       make(map[string]bool),
+		lastUser:    make(map[string]time.Time),
+		lastChannel: make(map[string]time.Time),
+		lastCommand: make(map[string]time.Time),
+	}
+
+	irc.OnUserStateMessage(func(message tw.UserStateMessage) {
+		isMod := message.Tags["mod"] == "1" || message.User.Badges["broadcaster"] > 0
+		o.mu.Lock()
+		o.modIn[message.Channel] = isMod
+		o.mu.Unlock()
+	})
+
+	return o
+}
+
+// Allow - можно ли сейчас ответить user в channel. Ничего не отмечает,
+// нужна чтобы не дергать нейронку зря, если ответ все равно не уйдет.
+func (o *Outbox) Allow(channel, user string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.allow(channel, user, false, time.Now())
+}
+
+func (o *Outbox) allow(channel, user string, command bool, now time.Time) bool {
+	if command {
+		return now.Sub(o.lastCommand[userKey(channel, user)]) >= o.cfg.UserCooldown
+	}
+	if now.Sub(o.lastChannel[channel]) < o.cfg.ChannelCooldown {
+		return false
+	}
+	if user != "" && now.Sub(o.lastUser[userKey(channel, user)]) < o.cfg.UserCooldown {
+		return false
+	}
+	return true
+}
+
+// Reply ставит ответ на сообщение parentID в очередь, все куски длинного ответа - тоже ответы.
+// user - кому отвечаем, для кулдауна; пустой - кулдаун только на канал.
+// false - сообщение выкинуто (кулдаун, очередь забита или пустой текст).
+func (o *Outbox) Reply(channel, parentID, user, text string) bool {
+	return o.enqueue(channel, parentID, user, text, false)
+}
+
+// Command - ответ на команду user. Кулдаун на канал не проверяет и не тратит,
+// иначе !help сразу после ответа на картинку молча пропадет. От спама командами -
+// свой кулдаун на юзера, отдельный от ответов на картинки.
+func (o *Outbox) Command(channel, parentID, user, text string) bool {
+	return o.enqueue(channel, parentID, user, text, true)
+}
+
+// Say ставит в очередь обычное сообщение в канал
+func (o *Outbox) Say(channel, text string) bool {
+	return o.enqueue(channel, "", "", text, false)
+}
+
+func (o *Outbox) enqueue(channel, parentID, user, text string, command bool) bool {
+	parts := FormatMessage(text, o.cfg.Format)
+	if len(parts) == 0 {
+		return false
+	}
+
+	now := time.Now()
+
+	// отправка в очередь не блокирует, поэтому лок держим до конца:
+	// иначе два ответа одновременно пройдут проверку кулдауна
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.allow(channel, user, command, now) {
+		logger.Infof("cooldown in %s for %q, dropping message", channel, user)
+		return false
+	}
+
+	o.replies++
+	queued := 0
+	for _, part := range parts {
+		select {
+		case o.queue <- outMessage{reply: o.replies, channel: channel, parentID: parentID, text: part, queuedAt: now}:
+			queued++
+			continue
+		default:
+			logger.Warnf("outbox queue is full, dropping %d of %d parts", len(parts)-queued, len(parts))
+		}
+		break
+	}
+	if queued == 0 {
+		return false // ничего не ушло - кулдаун не тратим
+	}
+
+	if command {
+		o.lastCommand[userKey(channel, user)] = now
+		return true
+	}
+
+	o.lastChannel[channel] = now
+	if user != "" {
+		o.lastUser[userKey(channel, user)] = now
+	}
+	return true
+}
+
+// Run отправляет сообщения из очереди, не превышая лимиты твича. Блокирует до отмены ctx.
+// Если кусок ответа протух, остальные куски того же ответа тоже выкидываются,
+// обрывок без начала в чате никому не нужен.
+func (o *Outbox) Run(ctx context.Context) {
+	var stale uint64 // номер протухшего ответа
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-o.queue:
+			if msg.reply == stale {
+				continue
+			}
+			if !o.waitForSlot(ctx, msg.channel) {
+				return
+			}
+			if age := time.Since(msg.queuedAt); age > o.cfg.MaxAge {
+				logger.Warnf("message to %s is %v old, dropping the rest of the reply", msg.channel, age.Round(time.Second))
+				stale = msg.reply
+				continue
+			}
+			o.send(msg)
+		}
+	}
+}
+
+// waitForSlot ждет пока окно в 30 секунд освободится. false - контекст отменили.
+// Все сообщения считаются в общий лимит на 100, а те что идут в каналы
+// без модерки - еще и в лимит на 20.
+func (o *Outbox) waitForSlot(ctx context.Context, channel string) bool {
+	for {
+		o.mu.Lock()
+		now := time.Now()
+		cutoff := now.Add(-rateWindow)
+		o.sent = trimOlder(o.sent, cutoff)
+		o.sentUser = trimOlder(o.sentUser, cutoff)
+
+		full := false
+		var wait time.Duration
+		if len(o.sent) >= rateLimitMod {
+			full, wait = true, o.sent[0].Add(rateWindow).Sub(now)
+		}
+		if !o.modIn[channel] && len(o.sentUser) >= rateLimitUser {
+			full, wait = true, max(wait, o.sentUser[0].Add(rateWindow).Sub(now))
+		}
+		o.mu.Unlock()
+		if !full {
+			return true
+		}
+
+		logger.Debugf("rate limit reached, waiting %v", wait)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (o *Outbox) send(msg outMessage) {
+	o.mu.Lock()
+	now := time.Now()
+	o.sent = append(o.sent, now)
+	if !o.modIn[msg.channel] {
+		o.sentUser = append(o.sentUser, now)
+	}
+	o.mu.Unlock()
+
+	if msg.parentID != "" {
+		o.irc.Reply(msg.channel, msg.parentID, msg.text)
+	} else {
+		o.irc.Say(msg.channel, msg.text)
+	}
+}
+
+// trimOlder выкидывает отметки старше cutoff, они отсортированы по времени
+func trimOlder(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+func userKey(channel, user string) string {
+	return channel + "/" + strings.ToLower(user)
+}